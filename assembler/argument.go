@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/tvarney/gotvm/op"
 	"github.com/tvarney/gotvm/vmerr"
@@ -24,6 +25,7 @@ const (
 	ArgUint64
 	ArgFloat32
 	ArgFloat64
+	ArgLabel
 )
 
 var (
@@ -34,12 +36,13 @@ var (
 		parseArgUint64,
 		parseArgFloat32,
 		parseArgFloat64,
+		parseArgLabel,
 	}
 )
 
 // Parse takes a 'line' of runes and parses a value according to the arg type.
 func (a ArgType) Parse(rest []rune, code op.ByteCode) ([]rune, op.ByteCode, error) {
-	if int(a) < 0 || int(a) > len(argParseLookup) {
+	if int(a) < 0 || int(a) >= len(argParseLookup) {
 		return rest, code, fmt.Errorf("%w: Unknown arg type %d", ErrInvalidArgType, int(a))
 	}
 	return argParseLookup[int(a)](rest, code)
@@ -120,6 +123,13 @@ func parseArgUint64(rest []rune, code op.ByteCode) ([]rune, op.ByteCode, error)
 	return rest, code, nil
 }
 
+// isFloatBits checks if a float argument is given as the raw bits of the
+// value, e.g. `0x7FC00001`. A hexadecimal float always has a `p` exponent, so
+// it is never mistaken for raw bits.
+func isFloatBits(value string) bool {
+	return strings.HasPrefix(value, "0x") && !strings.ContainsAny(value, "pP")
+}
+
 // parseArgFloat32 implements the argument parsing logic for a 32-bit float.
 func parseArgFloat32(rest []rune, code op.ByteCode) ([]rune, op.ByteCode, error) {
 	if len(rest) <= 0 {
@@ -127,6 +137,11 @@ func parseArgFloat32(rest []rune, code op.ByteCode) ([]rune, op.ByteCode, error)
 		return nil, code, ErrInvalidArgCount
 	}
 	strval, rest, _ := CutSpace(rest)
+	if isFloatBits(strval) {
+		bits, err := ParseUint(strval, 32)
+		code = append(code, op.Op(bits))
+		return rest, code, err
+	}
 	fval, err := strconv.ParseFloat(strval, 32)
 	if err != nil {
 		code = append(code, 0)
@@ -143,17 +158,35 @@ func parseArgFloat64(rest []rune, code op.ByteCode) ([]rune, op.ByteCode, error)
 		return nil, code, ErrInvalidArgCount
 	}
 	strval, rest, _ := CutSpace(rest)
-	fval, err := strconv.ParseFloat(strval, 64)
-	if err != nil {
-		code = append(code, 0, 0)
-		return rest, code, fmt.Errorf("%w: invalid float value %q", ErrInvalidArgValue, strval)
+	var uval uint64
+	if isFloatBits(strval) {
+		bits, err := ParseUint(strval, 64)
+		if err != nil {
+			code = append(code, 0, 0)
+			return rest, code, err
+		}
+		uval = bits
+	} else {
+		fval, err := strconv.ParseFloat(strval, 64)
+		if err != nil {
+			code = append(code, 0, 0)
+			return rest, code, fmt.Errorf("%w: invalid float value %q", ErrInvalidArgValue, strval)
+		}
+		uval = math.Float64bits(fval)
 	}
-	uval := math.Float64bits(fval)
 	code = append(
 		code,
 		op.Op(uint32((uval&0xFFFFFFFF00000000)>>32)),
 		op.Op(uint32(uval&0x00000000FFFFFFFF)),
 	)
 	return rest, code, nil
+}
 
+// parseArgLabel implements the argument parsing logic for a literal relative
+// offset given in place of a label.
+//
+// References to named labels can't be resolved by a single argument and are
+// handled by the assembler itself; this only accepts a 32-bit int offset.
+func parseArgLabel(rest []rune, code op.ByteCode) ([]rune, op.ByteCode, error) {
+	return parseArgInt32(rest, code)
 }
@@ -89,6 +89,16 @@ func testArgTypeParse(t *testing.T) {
 		{"f64-invalid-multiple-args", assembler.ArgFloat64, r("abc 10"), b(0, 0), r("10"), assembler.ErrInvalidArgValue},
 		{"f64-valid-only-arg", assembler.ArgFloat64, r("9123456789.0"), b(0x4200FE67, 0x38A80000), nil, nil},
 		{"f64-valid-multiple-args", assembler.ArgFloat64, r("70123456789.0 1.5"), b(0x423053AF, 0x09150000), r("1.5"), nil},
+		{"f32-bits", assembler.ArgFloat32, r("0x7FC00001"), b(0x7FC00001), nil, nil},
+		{"f32-bits-overflow", assembler.ArgFloat32, r("0x100000000"), b(0), nil, assembler.ErrInvalidArgValue},
+		{"f32-hex-float", assembler.ArgFloat32, r("0x1.5p3"), b(0x41280000), nil, nil},
+		{"f64-bits", assembler.ArgFloat64, r("0x7FF8000000000001 1.5"), b(0x7FF80000, 0x00000001), r("1.5"), nil},
+		{"f64-bits-invalid", assembler.ArgFloat64, r("0xZZ"), b(0, 0), nil, assembler.ErrInvalidArgValue},
+
+		{"label-nil", assembler.ArgLabel, nil, b(0), nil, assembler.ErrInvalidArgCount},
+		{"label-name", assembler.ArgLabel, r("loop"), b(0), nil, assembler.ErrInvalidArgValue},
+		{"label-valid-offset", assembler.ArgLabel, r("10"), b(10), nil, nil},
+		{"label-valid-negative", assembler.ArgLabel, r("-10 12"), b(0xFFFFFFF6), r("12"), nil},
 	}
 
 	for _, test := range tests {
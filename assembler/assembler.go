@@ -3,6 +3,7 @@ package assembler
 import (
 	"fmt"
 	"strings"
+	"unicode"
 
 	"github.com/tvarney/gotvm/op"
 	"github.com/tvarney/gotvm/vmerr"
@@ -10,6 +11,9 @@ import (
 
 const (
 	ErrInvalidArgCount = vmerr.ConstError("incorrect number of arguments")
+	ErrInvalidLabel    = vmerr.ConstError("invalid label")
+	ErrDuplicateLabel  = vmerr.ConstError("duplicate label")
+	ErrUndefinedLabel  = vmerr.ConstError("undefined label")
 )
 
 type Definition struct {
@@ -26,8 +30,26 @@ func newdef(name string, opcode op.Op, args ...ArgType) Definition {
 	}
 }
 
+// labelRef is a reference to a named label which can only be resolved once
+// every label in the program is known.
+type labelRef struct {
+	Name   string
+	Offset int // The index of the operand to fill in
+	Base   int // The index of the opcode the operand is relative to
+	LineNo int
+	Line   string
+}
+
 func (d *Definition) Parse(code op.ByteCode, argvalues []rune) (op.ByteCode, error) {
+	return d.parse(code, argvalues, nil)
+}
+
+// parse implements Parse, recording any references to named labels in refs.
+//
+// If refs is nil, label arguments must be literal offsets.
+func (d *Definition) parse(code op.ByteCode, argvalues []rune, refs *[]labelRef) (op.ByteCode, error) {
 	// Append our bytecode
+	base := len(code)
 	code = append(code, d.Value)
 
 	if len(d.Arguments) == 0 && len(argvalues) > 0 {
@@ -36,6 +58,14 @@ func (d *Definition) Parse(code op.ByteCode, argvalues []rune) (op.ByteCode, err
 
 	var err error
 	for _, arg := range d.Arguments {
+		if arg == ArgLabel && refs != nil {
+			name, rest, _ := CutSpace(argvalues)
+			if IsLabel(name) {
+				*refs = append(*refs, labelRef{Name: name, Offset: len(code), Base: base})
+				argvalues, code = rest, append(code, 0)
+				continue
+			}
+		}
 		rest, result, parseErr := arg.Parse(argvalues, code)
 		argvalues, code = rest, result
 		if parseErr != nil && err == nil {
@@ -54,25 +84,27 @@ func (d *Definition) Parse(code op.ByteCode, argvalues []rune) (op.ByteCode, err
 }
 
 var (
-	ops = []Definition{
-		newdef("Noop", op.Noop),
-		newdef("Halt", op.Halt),
-		newdef("PushI32", op.PushInt32, ArgInt32),
-		newdef("PushI64", op.PushInt64, ArgInt64),
-		newdef("PushU32", op.PushUint32, ArgUint32),
-		newdef("PushU64", op.PushUint64, ArgUint64),
-		newdef("PushF32", op.PushFloat32, ArgFloat32),
-		newdef("PushF64", op.PushFloat64, ArgFloat64),
-		newdef("Pop", op.Pop),
-		newdef("PopN", op.PopN, ArgUint32),
-		newdef("Copy", op.Copy, ArgUint32),
-		newdef("Swap", op.Swap, ArgUint32),
-		newdef("Negative", op.Negative),
-		newdef("AddInt", op.AddInt),
+	// argTypes maps the operand kinds of opcodes to the argument type which
+	// parses them
+	argTypes = [...]ArgType{
+		op.OperandInt32:   ArgInt32,
+		op.OperandInt64:   ArgInt64,
+		op.OperandUint32:  ArgUint32,
+		op.OperandUint64:  ArgUint64,
+		op.OperandFloat32: ArgFloat32,
+		op.OperandFloat64: ArgFloat64,
+		op.OperandOffset:  ArgLabel,
 	}
 	definitions = map[string]Definition{}
 )
 
+// Lookup returns the Definition of the opcode with the given name. Names are
+// not case sensitive.
+func Lookup(name string) (Definition, bool) {
+	def, ok := definitions[strings.ToLower(name)]
+	return def, ok
+}
+
 // RemoveComment removes a comment from the line
 func RemoveComment(line string) string {
 	idx := strings.IndexRune(line, ';')
@@ -88,12 +120,43 @@ type AssembleError struct {
 	Message string
 }
 
+// IsLabel checks if the given string is a valid label name.
+//
+// A label name starts with a letter or underscore, followed by any number of
+// letters, digits or underscores. Names which could be read as an integer
+// value (e.g. `b101`) are not valid labels.
+func IsLabel(name string) bool {
+	if name == "" {
+		return false
+	}
+	for idx, r := range name {
+		if r == '_' || unicode.IsLetter(r) || (idx > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	_, err := ParseInt(name, 64)
+	return err != nil
+}
+
 // Assemble takes a text file and converts it to bytecode.
 //
 // The syntax of a line in the assembly is:
 //
-//	OPCODE [ARG ARG ...] [; Comment]
+//	[LABEL:] [OPCODE [ARG ARG ...]] [; Comment]
+//
+// Arguments to branching opcodes may name a label instead of giving a literal
+// offset, and the label may be defined before or after it is used.
 func Assemble(lines []string, report func(AssembleError)) op.ByteCode {
+	code, _ := AssembleDebug(lines, report)
+	return code
+}
+
+// AssembleDebug assembles the lines like Assemble, and also returns the debug
+// info mapping each instruction to the line it was assembled from and each
+// label to its offset. If several labels name the same offset, the first one
+// is used as the symbol.
+func AssembleDebug(lines []string, report func(AssembleError)) (op.ByteCode, *op.DebugInfo) {
 	if report == nil {
 		report = ReportDiscard
 	}
@@ -103,6 +166,9 @@ func Assemble(lines []string, report func(AssembleError)) op.ByteCode {
 		size = 10
 	}
 	code := make(op.ByteCode, 0, size)
+	labels := map[string]int{}
+	debug := op.NewDebugInfo()
+	var refs []labelRef
 
 	for idx, line := range lines {
 		line = strings.TrimSpace(RemoveComment(line))
@@ -113,29 +179,68 @@ func Assemble(lines []string, report func(AssembleError)) op.ByteCode {
 		runes := []rune(line)
 
 		opcode, rest, _ := CutSpace(runes)
-		def, ok := definitions[strings.ToLower(opcode)]
+		if strings.HasSuffix(opcode, ":") {
+			name := strings.TrimSuffix(opcode, ":")
+			if _, ok := labels[name]; ok {
+				report(AssembleError{idx + 1, line, fmt.Sprintf("%s %q", ErrDuplicateLabel, name)})
+			} else if !IsLabel(name) {
+				report(AssembleError{idx + 1, line, fmt.Sprintf("%s %q", ErrInvalidLabel, name)})
+			} else {
+				labels[name] = len(code)
+				if _, ok := debug.Symbols[len(code)]; !ok {
+					debug.Symbols[len(code)] = name
+				}
+			}
+			if len(rest) == 0 {
+				continue
+			}
+			opcode, rest, _ = CutSpace(rest)
+		}
+
+		def, ok := Lookup(opcode)
 		if !ok {
 			report(AssembleError{idx + 1, line, fmt.Sprintf("invalid opcode %q", opcode)})
 			continue
 		}
 
-		result, err := def.Parse(code, rest)
+		debug.Lines[len(code)] = idx + 1
+		first := len(refs)
+		result, err := def.parse(code, rest, &refs)
 		code = result
+		for i := first; i < len(refs); i++ {
+			refs[i].LineNo = idx + 1
+			refs[i].Line = line
+		}
 		if err != nil {
 			report(AssembleError{idx + 1, line, err.Error()})
 			continue
 		}
 	}
 
+	for _, ref := range refs {
+		target, ok := labels[ref.Name]
+		if !ok {
+			report(AssembleError{ref.LineNo, ref.Line, fmt.Sprintf("%s %q", ErrUndefinedLabel, ref.Name)})
+			continue
+		}
+		code[ref.Offset] = op.Op(uint32(int32(target - ref.Base)))
+	}
+
 	if len(code) == 0 {
-		return nil
+		return nil, debug
 	}
-	return code
+	return code, debug
 }
 
 func init() {
-	// There isn't really a great way to do this
-	for _, def := range ops {
+	// Every opcode in the op package is available in assembly
+	for opcode := op.Op(0); opcode.Valid(); opcode++ {
+		meta, _ := op.Info(opcode)
+		args := make([]ArgType, 0, len(meta.Operands))
+		for _, operand := range meta.Operands {
+			args = append(args, argTypes[operand])
+		}
+		def := newdef(meta.Mnemonic, opcode, args...)
 		definitions[strings.ToLower(def.Name)] = def
 	}
 }
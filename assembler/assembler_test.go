@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tvarney/gotvm/assembler"
 	"github.com/tvarney/gotvm/op"
 )
@@ -105,6 +106,57 @@ func TestAssemble(t *testing.T) {
 				a(2, "PushI32 abc", "invalid argument value: invalid integer value: strconv.ParseInt: parsing \"abc\": invalid syntax"),
 			),
 		},
+		{
+			"label-backward",
+			"noop\nloop:\nnoop\njump loop",
+			b(op.Noop, op.Noop, op.Jump, 0xFFFFFFFF),
+			nil,
+		},
+		{
+			"label-forward",
+			"jumpifzero end\nnoop\nend: halt",
+			b(op.JumpIfZero, 3, op.Noop, op.Halt),
+			nil,
+		},
+		{
+			"label-call",
+			"call func 2\nhalt\nfunc:\nreturn 1",
+			b(op.Call, 4, 2, op.Halt, op.Return, 1),
+			nil,
+		},
+		{
+			"label-literal-offset",
+			"jump -2",
+			b(op.Jump, 0xFFFFFFFE),
+			nil,
+		},
+		{
+			"label-at-end",
+			"jump end\nend:",
+			b(op.Jump, 2),
+			nil,
+		},
+		{
+			"label-undefined",
+			"noop\njump nowhere\nhalt",
+			b(op.Noop, op.Jump, 0, op.Halt),
+			ae(a(2, "jump nowhere", "undefined label \"nowhere\"")),
+		},
+		{
+			"label-duplicate",
+			"start: noop\nstart: halt\njump start",
+			b(op.Noop, op.Halt, op.Jump, 0xFFFFFFFE),
+			ae(a(2, "start: halt", "duplicate label \"start\"")),
+		},
+		{
+			"label-invalid",
+			"0x10: noop\n: halt",
+			b(op.Noop, op.Halt),
+			ae(
+				a(1, "0x10: noop", "invalid label \"0x10\""),
+				a(2, ": halt", "invalid label \"\""),
+			),
+		},
 	}
 
 	for _, test := range tests {
@@ -124,3 +176,208 @@ func TestAssemble(t *testing.T) {
 		})
 	}
 }
+
+func TestAssembleDebug(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{
+		"; comment",
+		"PushI32 1",
+		"",
+		"loop: Decrement",
+		"    Copy 0   ; top",
+		"    JumpIfNotZero loop",
+	}
+	code, debug := assembler.AssembleDebug(lines, nil)
+	assert.Equal(t, op.ByteCode{op.PushInt32, 1, op.Decrement, op.Copy, 0, op.JumpIfNotZero, 0xFFFFFFFD}, code)
+	assert.Equal(t, map[int]int{0: 2, 2: 4, 3: 5, 5: 6}, debug.Lines)
+	assert.Equal(t, map[int]string{2: "loop"}, debug.Symbols)
+	assert.Equal(t, 5, debug.Line(3))
+	assert.Zero(t, debug.Line(1))
+	assert.Equal(t, "loop", debug.Symbol(2))
+	assert.Empty(t, debug.Symbol(0))
+}
+
+func TestIsLabel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Value    string
+		Expected bool
+	}{
+		{"empty", "", false},
+		{"simple", "loop", true},
+		{"underscore", "_start", true},
+		{"digits", "loop2", true},
+		{"leading-digit", "2loop", false},
+		{"punctuation", "lo-op", false},
+		{"integer", "10", false},
+		{"binary-integer", "b101", false},
+		{"octal-integer", "o17", false},
+		{"not-integer", "b12", true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.Expected, assembler.IsLabel(test.Value))
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	argTypes := map[op.Operand]assembler.ArgType{
+		op.OperandInt32:   assembler.ArgInt32,
+		op.OperandInt64:   assembler.ArgInt64,
+		op.OperandUint32:  assembler.ArgUint32,
+		op.OperandUint64:  assembler.ArgUint64,
+		op.OperandFloat32: assembler.ArgFloat32,
+		op.OperandFloat64: assembler.ArgFloat64,
+		op.OperandOffset:  assembler.ArgLabel,
+	}
+
+	for opcode := op.Op(0); opcode.Valid(); opcode++ {
+		meta, _ := op.Info(opcode)
+		def, ok := assembler.Lookup(meta.Mnemonic)
+		if !assert.True(t, ok, "%s is not defined in the assembler", meta.Mnemonic) {
+			continue
+		}
+		assert.Equal(t, opcode, def.Value)
+		require.Len(t, def.Arguments, len(meta.Operands), "%s has the wrong number of arguments", meta.Mnemonic)
+		for idx, operand := range meta.Operands {
+			assert.Equal(t, argTypes[operand], def.Arguments[idx], "%s argument %d", meta.Mnemonic, idx)
+		}
+	}
+
+	_, ok := assembler.Lookup("pushi32")
+	assert.True(t, ok, "lookup should not be case sensitive")
+	_, ok = assembler.Lookup("oops")
+	assert.False(t, ok)
+}
+
+func TestAssembleOpcodes(t *testing.T) {
+	t.Parallel()
+
+	b := func(values ...op.Op) op.ByteCode {
+		return values
+	}
+
+	tests := []struct {
+		Line             string
+		ExpectedByteCode op.ByteCode
+	}{
+		{"Noop", b(op.Noop)},
+		{"Halt", b(op.Halt)},
+		{"PushI32 -2", b(op.PushInt32, 0xFFFFFFFE)},
+		{"PushI64 0x100000002", b(op.PushInt64, 1, 2)},
+		{"PushU32 0xFFFFFFFF", b(op.PushUint32, 0xFFFFFFFF)},
+		{"PushU64 0x300000004", b(op.PushUint64, 3, 4)},
+		{"PushF32 1.5", b(op.PushFloat32, 0x3FC00000)},
+		{"PushF64 1.5", b(op.PushFloat64, 0x3FF80000, 0)},
+		{"Pop", b(op.Pop)},
+		{"PopN 3", b(op.PopN, 3)},
+		{"Copy 1", b(op.Copy, 1)},
+		{"Swap 2", b(op.Swap, 2)},
+		{"Negative", b(op.Negative)},
+		{"AddInt", b(op.AddInt)},
+		{"SubInt", b(op.SubInt)},
+		{"MulInt", b(op.MulInt)},
+		{"DivInt", b(op.DivInt)},
+		{"AddConstI32 -1", b(op.AddConstInt32, 0xFFFFFFFF)},
+		{"AddConstI64 -1", b(op.AddConstInt64, 0xFFFFFFFF, 0xFFFFFFFF)},
+		{"AddConstU32 5", b(op.AddConstUint32, 5)},
+		{"AddConstU64 5", b(op.AddConstUint64, 0, 5)},
+		{"AddConstF32 2.0", b(op.AddConstFloat32, 0x40000000)},
+		{"AddConstF64 2.0", b(op.AddConstFloat64, 0x40000000, 0)},
+		{"SubConstI32 7", b(op.SubConstInt32, 7)},
+		{"SubConstI64 7", b(op.SubConstInt64, 0, 7)},
+		{"SubConstU32 7", b(op.SubConstUint32, 7)},
+		{"SubConstU64 7", b(op.SubConstUint64, 0, 7)},
+		{"SubConstF32 -2.0", b(op.SubConstFloat32, 0xC0000000)},
+		{"SubConstF64 -2.0", b(op.SubConstFloat64, 0xC0000000, 0)},
+		{"MulConstI32 3", b(op.MulConstInt32, 3)},
+		{"MulConstI64 3", b(op.MulConstInt64, 0, 3)},
+		{"MulConstU32 3", b(op.MulConstUint32, 3)},
+		{"MulConstU64 3", b(op.MulConstUint64, 0, 3)},
+		{"MulConstF32 0.5", b(op.MulConstFloat32, 0x3F000000)},
+		{"MulConstF64 0.5", b(op.MulConstFloat64, 0x3FE00000, 0)},
+		{"DivConstI32 4", b(op.DivConstInt32, 4)},
+		{"DivConstI64 4", b(op.DivConstInt64, 0, 4)},
+		{"DivConstU32 4", b(op.DivConstUint32, 4)},
+		{"DivConstU64 4", b(op.DivConstUint64, 0, 4)},
+		{"DivConstF32 4.0", b(op.DivConstFloat32, 0x40800000)},
+		{"DivConstF64 4.0", b(op.DivConstFloat64, 0x40100000, 0)},
+		{"Increment", b(op.Increment)},
+		{"Decrement", b(op.Decrement)},
+		{"Call 6 2", b(op.Call, 6, 2)},
+		{"NativeCall 1 2", b(op.NativeCall, 1, 2)},
+		{"Return 1", b(op.Return, 1)},
+		{"Jump -4", b(op.Jump, 0xFFFFFFFC)},
+		{"JumpIfZero 4", b(op.JumpIfZero, 4)},
+		{"JumpIfNotZero 4", b(op.JumpIfNotZero, 4)},
+		{"JumpIfZeroInt 4", b(op.JumpIfZeroInt, 4)},
+		{"JumpIfZeroUint 4", b(op.JumpIfZeroUint, 4)},
+		{"JumpIfZeroFloat 4", b(op.JumpIfZeroFloat, 4)},
+		{"JumpIfNotZeroInt 4", b(op.JumpIfNotZeroInt, 4)},
+		{"JumpIfNotZeroUint 4", b(op.JumpIfNotZeroUint, 4)},
+		{"JumpIfNotZeroFloat 4", b(op.JumpIfNotZeroFloat, 4)},
+		{"Eq", b(op.Eq)},
+		{"Ne", b(op.Ne)},
+		{"Lt", b(op.Lt)},
+		{"Le", b(op.Le)},
+		{"Gt", b(op.Gt)},
+		{"Ge", b(op.Ge)},
+		{"Cmp", b(op.Cmp)},
+	}
+
+	covered := map[op.Op]bool{}
+	for _, test := range tests {
+		covered[test.ExpectedByteCode[0]] = true
+	}
+	for opcode := op.Op(0); opcode.Valid(); opcode++ {
+		assert.True(t, covered[opcode], "no assembly test for %s", opcode)
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Line, func(t *testing.T) {
+			t.Parallel()
+
+			var errors []assembler.AssembleError
+			report := func(err assembler.AssembleError) {
+				errors = append(errors, err)
+			}
+			code := assembler.Assemble([]string{test.Line}, report)
+			assert.Equal(t, test.ExpectedByteCode, code)
+			assert.Empty(t, errors)
+		})
+	}
+}
+
+func FuzzAssemble(f *testing.F) {
+	f.Add("noop\nPushI32 10\nPushF64 1.5\nAddInt")
+	f.Add("loop: jump loop\ncall func 2\nfunc: return 1")
+	f.Add("PushU64 0xDEADBEEFCAFED00D ; comment\n: halt\n0x10: noop")
+	f.Add("PopN\nSwap 1 2\nPushI32 -")
+
+	f.Fuzz(func(t *testing.T, source string) {
+		var errors []assembler.AssembleError
+		report := func(err assembler.AssembleError) {
+			errors = append(errors, err)
+		}
+		code := assembler.Assemble(strings.Split(source, "\n"), report)
+		for _, err := range errors {
+			assert.NotEmpty(t, err.Message)
+		}
+		if len(errors) == 0 {
+			// Every instruction in valid output must be a defined opcode
+			for idx := 0; idx < len(code); idx += code[idx].Size() {
+				assert.True(t, code[idx].Valid(), "invalid opcode at %d", idx)
+			}
+		}
+	})
+}
@@ -9,3 +9,8 @@ func ReportDiscard(_ AssembleError) {}
 func ReportPrint(err AssembleError) {
 	_, _ = fmt.Printf("Assembler Error on line %d: %s", err.LineNo, err.Message)
 }
+
+// ReportWarning prints assembler warnings to standard out.
+func ReportWarning(err AssembleError) {
+	_, _ = fmt.Printf("Assembler Warning on line %d: %s\n", err.LineNo, err.Message)
+}
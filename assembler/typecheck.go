@@ -0,0 +1,33 @@
+package assembler
+
+import (
+	"strings"
+
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/typecheck"
+)
+
+// CheckTypes infers the types of the values on the stack of assembled code,
+// and reports each instruction which is always given a value of the wrong
+// type. The lines and debug info are those the code was assembled from, and
+// are used to find the line of each instruction.
+//
+// Nothing is reported if the code is rejected by the verifier, as the types
+// of an invalid program can't be inferred.
+func CheckTypes(lines []string, code op.ByteCode, debug *op.DebugInfo, report func(AssembleError)) {
+	if report == nil {
+		return
+	}
+	result, err := typecheck.Infer(code)
+	if err != nil {
+		return
+	}
+	for _, mismatch := range result.Mismatches {
+		lineno := debug.Line(mismatch.Offset)
+		line := ""
+		if lineno > 0 && lineno <= len(lines) {
+			line = strings.TrimSpace(RemoveComment(lines[lineno-1]))
+		}
+		report(AssembleError{lineno, line, mismatch.Error()})
+	}
+}
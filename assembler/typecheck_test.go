@@ -0,0 +1,55 @@
+package assembler_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm/assembler"
+)
+
+func TestCheckTypes(t *testing.T) {
+	t.Parallel()
+
+	a := func(lineno int, line, msg string) assembler.AssembleError {
+		return assembler.AssembleError{lineno, line, msg}
+	}
+
+	tests := []struct {
+		Name     string
+		Source   string
+		Expected []assembler.AssembleError
+	}{
+		{"empty", "", nil},
+		{"valid", "PushI32 1\nPushI64 2\nAddInt", nil},
+		{
+			"mismatch",
+			"PushI32 1\n\nPushF32 2 ; the wrong type\nAddInt ; adds them",
+			[]assembler.AssembleError{a(4, "AddInt", "AddInt at offset 4 takes int, got float")},
+		},
+		{
+			"several",
+			"PushU32 1\nAddConstI32 1\nPushF32 1\nEq",
+			[]assembler.AssembleError{
+				a(2, "AddConstI32 1", "AddConstI32 at offset 2 takes int, got uint"),
+				a(4, "Eq", "Eq at offset 6 takes int, got float"),
+			},
+		},
+		{"rejected", "PushF32 1\nAddInt", nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			lines := strings.Split(test.Source, "\n")
+			code, debug := assembler.AssembleDebug(lines, nil)
+			var warnings []assembler.AssembleError
+			assembler.CheckTypes(lines, code, debug, func(err assembler.AssembleError) {
+				warnings = append(warnings, err)
+			})
+			assert.Equal(t, test.Expected, warnings)
+		})
+	}
+}
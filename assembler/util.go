@@ -85,14 +85,15 @@ func ParseInt(value string, bitsize int) (int64, error) {
 		return 0, fmt.Errorf("%w: integer may not be only base prefix", ErrInvalidArgValue)
 	}
 
-	// Parse the value now
+	// Parse the value now; the sign is put back so that the minimum value of
+	// the bit size is in range
+	if negative {
+		value = "-" + value
+	}
 	ival, err := strconv.ParseInt(value, base, bitsize)
 	if err != nil {
 		return 0, fmt.Errorf("%w: invalid integer value: %s", ErrInvalidArgValue, err.Error())
 	}
-	if negative {
-		ival = -ival
-	}
 	return ival, nil
 }
 
@@ -1,6 +1,7 @@
 package assembler_test
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -100,6 +101,13 @@ func TestParseInt(t *testing.T) {
 		{"base2-short-valid-negative", "-b101", 32, -5, nil},
 		{"base2-short-valid-positive", "+b101", 32, 5, nil},
 		{"base2-short-invalid", "b123", 32, 0, assembler.ErrInvalidArgValue},
+		{"min-int32", "-2147483648", 32, math.MinInt32, nil},
+		{"min-int32-hex", "-0x80000000", 32, math.MinInt32, nil},
+		{"max-int32", "2147483647", 32, math.MaxInt32, nil},
+		{"overflow-int32", "2147483648", 32, 0, assembler.ErrInvalidArgValue},
+		{"underflow-int32", "-2147483649", 32, 0, assembler.ErrInvalidArgValue},
+		{"min-int64", "-9223372036854775808", 64, math.MinInt64, nil},
+		{"double-sign", "--1", 32, 0, assembler.ErrInvalidArgValue},
 	}
 
 	for _, test := range tests {
@@ -166,3 +174,39 @@ func TestParseUint(t *testing.T) {
 		})
 	}
 }
+
+func FuzzParseInt(f *testing.F) {
+	for _, seed := range []string{"", "-", "0", "-0x10", "+0b101", "o17", "079", "0xDEADBEEF", "b"} {
+		f.Add(seed, 32)
+		f.Add(seed, 64)
+	}
+
+	f.Fuzz(func(t *testing.T, value string, bitsize int) {
+		if bitsize < 0 || bitsize > 64 {
+			t.Skip("bit size is out of range for strconv")
+		}
+		v, err := assembler.ParseInt(value, bitsize)
+		if err != nil {
+			assert.ErrorIs(t, err, assembler.ErrInvalidArgValue)
+			assert.Equal(t, int64(0), v)
+		}
+	})
+}
+
+func FuzzParseUint(f *testing.F) {
+	for _, seed := range []string{"", "0", "0x10", "0b101", "o17", "079", "0x1DEADBEEF", "b"} {
+		f.Add(seed, 32)
+		f.Add(seed, 64)
+	}
+
+	f.Fuzz(func(t *testing.T, value string, bitsize int) {
+		if bitsize < 0 || bitsize > 64 {
+			t.Skip("bit size is out of range for strconv")
+		}
+		v, err := assembler.ParseUint(value, bitsize)
+		if err != nil {
+			assert.ErrorIs(t, err, assembler.ErrInvalidArgValue)
+			assert.Equal(t, uint64(0), v)
+		}
+	})
+}
@@ -1,26 +1,55 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/alecthomas/kingpin"
 	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/container"
+	"github.com/tvarney/gotvm/disassembler"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/optimize"
 	"github.com/tvarney/gotvm/reference"
+	"github.com/tvarney/gotvm/verify"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
 )
 
 func main() {
 	showBytecode := false
 	showStack := false
+	disassemble := false
+	verifyCode := false
+	optimizeCode := false
+	foldCode := true
+	foldSet := false
 	trace := false
+	gasLimit := uint64(0)
+	maxStack := 0
+	maxFrames := 0
 	filename := ""
+	output := ""
 
 	argparse := kingpin.New("runner", "Run an assembly program in the VM")
 	argparse.Flag("show-bytecode", "Print the raw bytecode after assembly").BoolVar(&showBytecode)
+	argparse.Flag("disassemble", "Print the disassembly of the bytecode and exit without running it").BoolVar(&disassemble)
+	argparse.Flag("verify", "Verify the bytecode before running it, and stop if it is rejected").BoolVar(&verifyCode)
+	argparse.Flag("optimize", "Optimize the bytecode before running or saving it").Short('O').BoolVar(&optimizeCode)
+	argparse.Flag("fold", "Fold arithmetic on constants when assembling, so the bytecode shown, disassembled and saved is the folded code; --no-fold keeps the code as written").Default("true").Action(func(*kingpin.ParseContext) error {
+		foldSet = true
+		return nil
+	}).BoolVar(&foldCode)
 	argparse.Flag("show-stack", "Print the values on the stack at the end of the program").BoolVar(&showStack)
-	argparse.Flag("trace", "Print the values of the stack after each opcode").BoolVar(&trace)
-	argparse.Arg("file", "The file to assemble and run").Required().StringVar(&filename)
+	argparse.Flag("trace", "Print each opcode with the stack before it runs, and each call and return").BoolVar(&trace)
+	argparse.Flag("gas-limit", "Stop the program after it uses this much gas; 0 is unlimited").Uint64Var(&gasLimit)
+	argparse.Flag("max-stack", "The maximum number of values on the stack; 0 is unlimited").IntVar(&maxStack)
+	argparse.Flag("max-frames", "The maximum depth of nested calls; 0 is unlimited").IntVar(&maxFrames)
+	argparse.Flag("output", "Write the assembled program to this file as a container and exit").Short('o').StringVar(&output)
+	argparse.Arg("file", "The assembly or container file to run").Required().StringVar(&filename)
 
 	if _, err := argparse.Parse(os.Args[1:]); err != nil {
 		fmt.Printf("Error: %v\n", err)
@@ -32,45 +61,192 @@ func main() {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
-	lines := strings.Split(string(content), "\n")
-	bytecode := assembler.Assemble(lines, assembler.ReportPrint)
-	if bytecode == nil {
-		fmt.Printf("Error: no bytecode assembled")
-		os.Exit(1)
+	var lines []string
+	var bytecode op.ByteCode
+	var debug *op.DebugInfo
+	if bytes.HasPrefix(content, []byte(container.Magic)) {
+		program, err := container.Decode(bytes.NewReader(content))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		bytecode, debug = program.Code, program.Debug
+	} else {
+		lines = strings.Split(string(content), "\n")
+		bytecode, debug = assembler.AssembleDebug(lines, assembler.ReportPrint)
+		if bytecode == nil {
+			fmt.Printf("Error: no bytecode assembled")
+			os.Exit(1)
+		}
+		assembler.CheckTypes(lines, bytecode, debug, assembler.ReportWarning)
+		if foldCode {
+			// By default a program which can't be folded is run as it is, so
+			// that its error is reported where it happens; an explicit --fold
+			// reports it here instead. The runner's VM is not strict, so
+			// neither is the folding
+			folded, moved, err := optimize.Fold(bytecode, debug, false)
+			switch {
+			case err == nil:
+				bytecode, debug = folded, moved
+			case foldSet:
+				printVerifyError(err, filename, lines, debug)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if optimizeCode {
+		optimized, moved, err := optimize.Optimize(bytecode, debug)
+		if err != nil {
+			printVerifyError(err, filename, lines, debug)
+			os.Exit(1)
+		}
+		bytecode, debug = optimized, moved
+	}
+
+	if output != "" {
+		if err := save(output, &container.Program{Code: bytecode, Debug: debug}); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	if showBytecode {
 		fmt.Printf("==ByteCode==\n")
-		for idx, op := range bytecode {
+		for idx, value := range bytecode {
 			if idx%4 == 0 && idx != 0 {
 				fmt.Printf("\n")
 			}
-			fmt.Printf("0x%08x ", uint32(op))
+			fmt.Printf("0x%08x ", uint32(value))
 		}
 		fmt.Printf("\n============\n")
 	}
 
-	vm := reference.New()
-	fmt.Printf("Running bytecode...\n")
-	if trace {
-		vm.Start(bytecode)
-		for {
-			if err := vm.Step(); err != nil {
-				if err != reference.ErrHalt {
-					fmt.Printf("Error running bytecode: %v\n", err)
-				}
-				break
-			}
-			fmt.Printf("Stack: %+v\n", vm.Stack)
+	if disassemble {
+		text, err := disassembler.Disassemble(bytecode, debug)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Println(strings.Join(text, "\n"))
+		return
+	}
 
-	} else {
-		if err := vm.Execute(bytecode); err != nil {
-			fmt.Printf("Error running bytecode: %v\n", err)
+	if verifyCode {
+		info, err := verify.Verify(bytecode)
+		if err != nil {
+			printVerifyError(err, filename, lines, debug)
+			os.Exit(1)
+		}
+		if info.MaxStack == verify.Unbounded {
+			fmt.Printf("Verified; the stack is unbounded\n")
+		} else {
+			fmt.Printf("Verified; at most %d values on the stack\n", info.MaxStack)
 		}
+	}
+
+	opts := []vmopt.Option{
+		vmopt.WithGasLimit(gasLimit),
+		vmopt.WithMaxStack(maxStack),
+		vmopt.WithMaxFrames(maxFrames),
+		vmopt.WithDebugInfo(debug),
+	}
+	if trace {
+		opts = append(opts, vmopt.WithTracer(printTracer{}))
+	}
+	vm := reference.New(opts...)
+	fmt.Printf("Running bytecode...\n")
+	if err := vm.Execute(bytecode); err != nil {
+		printError(err, filename, lines, debug)
+	}
+	if showStack {
+		fmt.Printf("Stack: %#v\n", vm.Stack)
+	}
+	if gasLimit != 0 {
+		fmt.Printf("Gas used: %d/%d\n", vm.GasUsed, gasLimit)
+	}
+}
 
-		if showStack {
-			fmt.Printf("Stack: %#v\n", vm.Stack)
+// save writes the program to the file as a container.
+func save(filename string, program *container.Program) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := program.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// printError prints an error from the VM. Runtime errors are printed with the
+// stack and a trace of the active functions, in the style of a Go panic.
+func printError(err error, filename string, lines []string, debug *op.DebugInfo) {
+	var runtimeErr vmerr.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		fmt.Printf("Error running bytecode: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Error running bytecode: %v\n", runtimeErr.Err)
+	fmt.Printf("stack: %v\n\n", runtimeErr.Stack)
+	offset, line := runtimeErr.Offset, runtimeErr.Line
+	for _, frame := range runtimeErr.Frames {
+		name := frame.Function
+		if name == "" && frame.CallSite < 0 {
+			name = "<top level>"
+		} else if name == "" {
+			name = fmt.Sprintf("<function at %d>", frame.Entry)
 		}
+		fmt.Printf("%s(...)\n", name)
+		printLocation(filename, lines, line, offset)
+		offset = frame.CallSite
+		line = debug.Line(offset)
+	}
+}
+
+// printVerifyError prints an error from the verifier with the location of the
+// rejected instruction.
+func printVerifyError(err error, filename string, lines []string, debug *op.DebugInfo) {
+	var verifyErr verify.Error
+	if !errors.As(err, &verifyErr) {
+		fmt.Printf("Error verifying bytecode: %v\n", err)
+		return
 	}
+	fmt.Printf("Error verifying bytecode: %v\n", verifyErr.Err)
+	printLocation(filename, lines, debug.Line(verifyErr.Offset), verifyErr.Offset)
+}
+
+// printLocation prints the location of an instruction, with the source line
+// if it is known.
+func printLocation(filename string, lines []string, line, offset int) {
+	if line <= 0 {
+		fmt.Printf("\t%s (offset %d)\n", filename, offset)
+		return
+	}
+	// A container has the line numbers but not the source lines
+	fmt.Printf("\t%s:%d (offset %d)\n", filename, line, offset)
+	if line <= len(lines) {
+		fmt.Printf("\t\t%s\n", strings.TrimSpace(assembler.RemoveComment(lines[line-1])))
+	}
+}
+
+// printTracer prints each instruction with the stack before it runs, and
+// each call and return.
+type printTracer struct {
+	vmopt.NopTracer
+}
+
+func (printTracer) OnInstruction(offset int, opcode op.Op, stack vmopt.StackView) {
+	fmt.Printf("%04d %-18s %v\n", offset, opcode, vmopt.Values(stack))
+}
+
+func (printTracer) OnCall(offset, target, depth int) {
+	fmt.Printf("---- call %04d (depth %d)\n", target, depth)
+}
+
+func (printTracer) OnReturn(offset, target, depth int) {
+	fmt.Printf("---- return to %04d (depth %d)\n", target, depth)
 }
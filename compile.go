@@ -0,0 +1,628 @@
+package gotvm
+
+import (
+	"context"
+	"math"
+
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/verify"
+	"github.com/tvarney/gotvm/vmerr"
+)
+
+// halt is returned by a handler in place of the index of the next
+// instruction to end the program.
+const halt = -1
+
+// handler runs a single compiled instruction, returning the index of the
+// instruction to run next. The index is not used if an error is returned.
+type handler func(vm *VirtualMachine) (int, error)
+
+// compiled is a single instruction of a Program.
+type compiled struct {
+	Run    handler
+	Offset int
+	OpCode op.Op
+	Cost   uint64
+}
+
+// Program is bytecode compiled ahead of time by Compile.
+//
+// Execute decodes the operands of an instruction every time it is run, and
+// picks the code to run it in one very large switch. A Program is decoded
+// once; each instruction becomes a handler with its operands resolved and
+// widened, and with branch targets turned into the index of the instruction
+// they land on. Running a Program calls one handler after another. Which of
+// the two is faster depends on the program, so both are kept; the benchmarks
+// in the vmtest package compare them.
+//
+// A Program is never modified once it is compiled, and may be run any number
+// of times by any number of VMs, including at the same time.
+type Program struct {
+	code  op.ByteCode
+	insts []compiled
+	index []int // The index of the instruction at each offset; len(insts) at len(code)
+}
+
+// Compile compiles the code into a Program.
+//
+// The code must pass verify.Verify; if it is rejected the error from Verify
+// is returned. The handlers rely on this to skip the checks a verified program
+// can never fail, such as popping a value which is not there. This is the one
+// difference from Execute, which runs a program up to the first instruction
+// which fails; a program using the stack left behind by an earlier program
+// must be run with Execute.
+func Compile(code op.ByteCode) (*Program, error) {
+	if _, err := verify.Verify(code); err != nil {
+		return nil, err
+	}
+
+	p := &Program{
+		code:  append(op.ByteCode(nil), code...),
+		index: make([]int, len(code)+1),
+	}
+	var insts []op.Instruction
+	for offset := 0; offset < len(code); {
+		inst, _ := op.Decode(code, offset)
+		p.index[offset] = len(insts)
+		insts = append(insts, inst)
+		offset = inst.Next()
+	}
+	p.index[len(code)] = len(insts)
+
+	p.insts = make([]compiled, len(insts))
+	for idx, inst := range insts {
+		p.insts[idx] = compiled{
+			Run:    p.compile(idx, inst),
+			Offset: inst.Offset,
+			OpCode: inst.Op,
+			Cost:   inst.Op.Cost(),
+		}
+	}
+	return p, nil
+}
+
+// Run runs the program on the VirtualMachine. The program runs exactly as it
+// would with Execute; the stack, gas, limits, tracer and errors are the same.
+func (p *Program) Run(vm *VirtualMachine) error {
+	return p.RunContext(context.Background(), vm)
+}
+
+// RunContext runs the program like Run, but stops with a
+// vmerr.InterruptedError if the context is done, as ExecuteContext does.
+func (p *Program) RunContext(ctx context.Context, vm *VirtualMachine) error {
+	offset, err := p.run(ctx, vm)
+	if err != nil {
+		err = vm.runtimeError(p.code, offset, err)
+		if vm.Tracer != nil {
+			vm.Tracer.OnError(err)
+		}
+		return err
+	}
+	if vm.Tracer != nil {
+		vm.Tracer.OnHalt((*stackView)(vm))
+	}
+	return nil
+}
+
+// run implements RunContext, returning the offset of the failing instruction
+// along with any error. This is the same loop as VirtualMachine.run, with the
+// switch replaced by a call to the handler.
+func (p *Program) run(ctx context.Context, vm *VirtualMachine) (int, error) {
+	vm.FrameBase = 0
+	vm.Frames = vm.Frames[:0]
+	vm.GasUsed = 0
+
+	insts := p.insts
+	done := ctx.Done()
+	check := 1
+	idx := 0
+	for idx >= 0 && idx < len(insts) {
+		inst := &insts[idx]
+		if done != nil {
+			if check--; check == 0 {
+				check = ContextCheckInterval
+				select {
+				case <-done:
+					return inst.Offset, vmerr.InterruptedError{OpCode: inst.OpCode.String(), Offset: inst.Offset, Err: ctx.Err()}
+				default:
+				}
+			}
+		}
+		if vm.GasLimit != 0 && vm.GasUsed+inst.Cost > vm.GasLimit {
+			return inst.Offset, vmerr.OutOfGasError{OpCode: inst.OpCode.String(), Offset: inst.Offset}
+		}
+		vm.GasUsed += inst.Cost
+		if vm.Tracer != nil {
+			vm.Tracer.OnInstruction(inst.Offset, inst.OpCode, (*stackView)(vm))
+		}
+		next, err := inst.Run(vm)
+		if err != nil {
+			return inst.Offset, err
+		}
+		idx = next
+	}
+	return 0, nil
+}
+
+// compile returns the handler for the instruction with the given index.
+//
+// The handlers make the same checks in the same order as Execute, other than
+// those made by verify.Verify.
+func (p *Program) compile(idx int, inst op.Instruction) handler {
+	next := idx + 1
+	name := inst.Op.String()
+	target := 0
+	if offset, ok := inst.Target(); ok {
+		target = p.index[offset]
+	}
+
+	switch inst.Op {
+	case op.Noop:
+		return func(*VirtualMachine) (int, error) {
+			return next, nil
+		}
+	case op.Halt:
+		return func(*VirtualMachine) (int, error) {
+			return halt, nil
+		}
+	case op.PushInt32, op.PushInt64, op.PushUint32, op.PushUint64, op.PushFloat32, op.PushFloat64:
+		v := constant(inst)
+		return func(vm *VirtualMachine) (int, error) {
+			if vm.full() {
+				return 0, vmerr.StackOverflowError{OpCode: name}
+			}
+			vm.push(v)
+			return next, nil
+		}
+	case op.Pop:
+		return func(vm *VirtualMachine) (int, error) {
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			return next, nil
+		}
+	case op.PopN:
+		n := int(uint32(inst.Args[0]))
+		return func(vm *VirtualMachine) (int, error) {
+			vm.Stack = vm.Stack[:len(vm.Stack)-n]
+			return next, nil
+		}
+	case op.Copy:
+		n := int(uint32(inst.Args[0]))
+		return func(vm *VirtualMachine) (int, error) {
+			if vm.full() {
+				return 0, vmerr.StackOverflowError{OpCode: name}
+			}
+			vm.push(vm.Stack[vm.FrameBase+n])
+			return next, nil
+		}
+	case op.Swap:
+		n := int(uint32(inst.Args[0]))
+		return func(vm *VirtualMachine) (int, error) {
+			ref, last := vm.FrameBase+n, len(vm.Stack)-1
+			vm.Stack[ref], vm.Stack[last] = vm.Stack[last], vm.Stack[ref]
+			return next, nil
+		}
+	case op.Negative:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			switch iv := vm.Stack[top]; iv.kind {
+			case KindFloat:
+				vm.Stack[top] = Float(-math.Float64frombits(iv.bits))
+			case KindUint:
+				vm.Stack[top] = Uint(-iv.bits)
+			case KindInt:
+				vm.Stack[top] = Int(-int64(iv.bits))
+			default:
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			return next, nil
+		}
+	case op.AddInt:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v1, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			v2, ok := vm.toInt(vm.Stack[top-1])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top-1] = Int(v1 + v2)
+			vm.Stack = vm.Stack[:top]
+			return next, nil
+		}
+	case op.SubInt:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v1, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			v2, ok := vm.toInt(vm.Stack[top-1])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top-1] = Int(v1 - v2)
+			vm.Stack = vm.Stack[:top]
+			return next, nil
+		}
+	case op.MulInt:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v1, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			v2, ok := vm.toInt(vm.Stack[top-1])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top-1] = Int(v1 * v2)
+			vm.Stack = vm.Stack[:top]
+			return next, nil
+		}
+	case op.DivInt:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v1, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			v2, ok := vm.toInt(vm.Stack[top-1])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			if v2 == 0 {
+				return 0, vmerr.DivisionByZeroError{OpCode: name}
+			}
+			if v1 == math.MinInt64 && v2 == -1 {
+				return 0, vmerr.IntegerOverflowError{OpCode: name}
+			}
+			vm.Stack[top-1] = Int(v1 / v2)
+			vm.Stack = vm.Stack[:top]
+			return next, nil
+		}
+	case op.AddConstInt32, op.AddConstInt64:
+		c := int64(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Int(c + v)
+			return next, nil
+		}
+	case op.AddConstUint32, op.AddConstUint64:
+		c := constant(inst).bits
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toUint(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Uint(c + v)
+			return next, nil
+		}
+	case op.AddConstFloat32, op.AddConstFloat64:
+		c := math.Float64frombits(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toFloat(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Float(c + v)
+			return next, nil
+		}
+	case op.SubConstInt32, op.SubConstInt64:
+		c := int64(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Int(v - c)
+			return next, nil
+		}
+	case op.SubConstUint32, op.SubConstUint64:
+		c := constant(inst).bits
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toUint(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Uint(v - c)
+			return next, nil
+		}
+	case op.SubConstFloat32, op.SubConstFloat64:
+		c := math.Float64frombits(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toFloat(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Float(v - c)
+			return next, nil
+		}
+	case op.MulConstInt32, op.MulConstInt64:
+		c := int64(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Int(c * v)
+			return next, nil
+		}
+	case op.MulConstUint32, op.MulConstUint64:
+		c := constant(inst).bits
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toUint(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Uint(c * v)
+			return next, nil
+		}
+	case op.MulConstFloat32, op.MulConstFloat64:
+		c := math.Float64frombits(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toFloat(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Float(c * v)
+			return next, nil
+		}
+	case op.DivConstInt32, op.DivConstInt64:
+		c := int64(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			if c == 0 {
+				return 0, vmerr.DivisionByZeroError{OpCode: name}
+			}
+			if v == math.MinInt64 && c == -1 {
+				return 0, vmerr.IntegerOverflowError{OpCode: name}
+			}
+			vm.Stack[top] = Int(v / c)
+			return next, nil
+		}
+	case op.DivConstUint32, op.DivConstUint64:
+		c := constant(inst).bits
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toUint(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			if c == 0 {
+				return 0, vmerr.DivisionByZeroError{OpCode: name}
+			}
+			vm.Stack[top] = Uint(v / c)
+			return next, nil
+		}
+	case op.DivConstFloat32, op.DivConstFloat64:
+		c := math.Float64frombits(constant(inst).bits)
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toFloat(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top] = Float(v / c)
+			return next, nil
+		}
+	case op.Increment:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			switch iv := vm.Stack[top]; iv.kind {
+			case KindInt:
+				vm.Stack[top] = Int(int64(iv.bits) + 1)
+			case KindUint:
+				vm.Stack[top] = Uint(iv.bits + 1)
+			case KindFloat:
+				vm.Stack[top] = Float(math.Float64frombits(iv.bits) + 1)
+			default:
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			return next, nil
+		}
+	case op.Decrement:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			switch iv := vm.Stack[top]; iv.kind {
+			case KindInt:
+				vm.Stack[top] = Int(int64(iv.bits) - 1)
+			case KindUint:
+				vm.Stack[top] = Uint(iv.bits - 1)
+			case KindFloat:
+				vm.Stack[top] = Float(math.Float64frombits(iv.bits) - 1)
+			default:
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			return next, nil
+		}
+	case op.Call:
+		argc := int(uint32(inst.Args[1]))
+		offset, entry := inst.Offset, inst.Offset+int(int32(inst.Args[0]))
+		ret := inst.Next()
+		return func(vm *VirtualMachine) (int, error) {
+			if vm.MaxFrames != 0 && len(vm.Frames) >= vm.MaxFrames {
+				return 0, vmerr.CallDepthExceededError{OpCode: name}
+			}
+			vm.Frames = append(vm.Frames, Frame{
+				ReturnAddress: ret,
+				FrameBase:     vm.FrameBase,
+				Entry:         entry,
+			})
+			vm.FrameBase = len(vm.Stack) - argc
+			if vm.Tracer != nil {
+				vm.Tracer.OnCall(offset, entry, len(vm.Frames))
+			}
+			return target, nil
+		}
+	case op.Return:
+		n := int(uint32(inst.Args[0]))
+		offset := inst.Offset
+		return func(vm *VirtualMachine) (int, error) {
+			copy(vm.Stack[vm.FrameBase:], vm.Stack[len(vm.Stack)-n:])
+			vm.Stack = vm.Stack[:vm.FrameBase+n]
+			if len(vm.Frames) == 0 {
+				// Returning from the top level ends the program
+				return halt, nil
+			}
+			frame := vm.Frames[len(vm.Frames)-1]
+			vm.Frames = vm.Frames[:len(vm.Frames)-1]
+			vm.FrameBase = frame.FrameBase
+			if vm.Tracer != nil {
+				vm.Tracer.OnReturn(offset, frame.ReturnAddress, len(vm.Frames))
+			}
+			return p.index[frame.ReturnAddress], nil
+		}
+	case op.Jump:
+		return func(*VirtualMachine) (int, error) {
+			return target, nil
+		}
+	case op.JumpIfZero, op.JumpIfNotZero:
+		onZero := inst.Op == op.JumpIfZero
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			zero, ok := isZero(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack = vm.Stack[:top]
+			if zero == onZero {
+				return target, nil
+			}
+			return next, nil
+		}
+	case op.JumpIfZeroInt, op.JumpIfNotZeroInt:
+		onZero := inst.Op == op.JumpIfZeroInt
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toInt(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack = vm.Stack[:top]
+			if (v == 0) == onZero {
+				return target, nil
+			}
+			return next, nil
+		}
+	case op.JumpIfZeroUint, op.JumpIfNotZeroUint:
+		onZero := inst.Op == op.JumpIfZeroUint
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toUint(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack = vm.Stack[:top]
+			if (v == 0) == onZero {
+				return target, nil
+			}
+			return next, nil
+		}
+	case op.JumpIfZeroFloat, op.JumpIfNotZeroFloat:
+		onZero := inst.Op == op.JumpIfZeroFloat
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			v, ok := vm.toFloat(vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack = vm.Stack[:top]
+			if (v == 0) == onZero {
+				return target, nil
+			}
+			return next, nil
+		}
+	case op.Eq:
+		return comparison(name, next, func(c int, ordered bool) bool { return ordered && c == 0 })
+	case op.Ne:
+		return comparison(name, next, func(c int, ordered bool) bool { return !ordered || c != 0 })
+	case op.Lt:
+		return comparison(name, next, func(c int, ordered bool) bool { return ordered && c < 0 })
+	case op.Le:
+		return comparison(name, next, func(c int, ordered bool) bool { return ordered && c <= 0 })
+	case op.Gt:
+		return comparison(name, next, func(c int, ordered bool) bool { return ordered && c > 0 })
+	case op.Ge:
+		return comparison(name, next, func(c int, ordered bool) bool { return ordered && c >= 0 })
+	case op.Cmp:
+		return func(vm *VirtualMachine) (int, error) {
+			top := len(vm.Stack) - 1
+			c, _, ok := vm.compare(vm.Stack[top-1], vm.Stack[top])
+			if !ok {
+				return 0, vmerr.InvalidTypeError{OpCode: name}
+			}
+			vm.Stack[top-1] = Int(int64(c))
+			vm.Stack = vm.Stack[:top]
+			return next, nil
+		}
+	case op.NativeCall:
+		index, argc := inst.Args[0], int(uint32(inst.Args[1]))
+		return func(vm *VirtualMachine) (int, error) {
+			if index >= uint64(len(vm.Natives)) {
+				return 0, vmerr.IndexOutOfBoundsError{OpCode: name}
+			}
+			if err := vm.callNative(name, vm.Natives[index], argc); err != nil {
+				return 0, err
+			}
+			return next, nil
+		}
+	}
+
+	// A verified program has no undefined opcodes
+	err := vmerr.InvalidOpcodeError{OpCode: uint32(inst.Op)}
+	return func(*VirtualMachine) (int, error) {
+		return 0, err
+	}
+}
+
+// comparison returns the handler for a comparison opcode which pushes 1 if
+// the result of comparing the two topmost values satisfies the test, and 0
+// otherwise.
+func comparison(name string, next int, test func(c int, ordered bool) bool) handler {
+	return func(vm *VirtualMachine) (int, error) {
+		top := len(vm.Stack) - 1
+		c, ordered, ok := vm.compare(vm.Stack[top-1], vm.Stack[top])
+		if !ok {
+			return 0, vmerr.InvalidTypeError{OpCode: name}
+		}
+		vm.Stack[top-1] = Int(boolInt(test(c, ordered)))
+		vm.Stack = vm.Stack[:top]
+		return next, nil
+	}
+}
+
+// constant returns the operand of an instruction taking a single constant,
+// widened to a Value of its type.
+func constant(inst op.Instruction) Value {
+	meta, _ := op.Info(inst.Op)
+	arg := inst.Args[0]
+	switch meta.Operands[0] {
+	case op.OperandInt32:
+		return Int(int64(int32(arg)))
+	case op.OperandInt64:
+		return Int(int64(arg))
+	case op.OperandUint32, op.OperandUint64:
+		return Uint(arg)
+	case op.OperandFloat32:
+		return Float(float64(math.Float32frombits(uint32(arg))))
+	}
+	return Float(math.Float64frombits(arg))
+}
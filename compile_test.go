@@ -0,0 +1,285 @@
+package gotvm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/gotvm"
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/verify"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+// compiledConfigs are the options the compiled programs are checked with.
+var compiledConfigs = map[string][]vmopt.Option{
+	"default": nil,
+	"strict":  {vmopt.WithStrict(true)},
+	"natives": {vmopt.WithNatives(vmopt.Native{Name: "double", Func: func(args []interface{}) ([]interface{}, error) {
+		results := make([]interface{}, 0, len(args)*2)
+		for _, arg := range args {
+			results = append(results, arg, arg)
+		}
+		return results, nil
+	}})},
+	"limits": {vmopt.WithMaxStack(4), vmopt.WithMaxFrames(1), vmopt.WithGasLimit(50)},
+}
+
+// sameAsExecute runs the program with Execute and compiled, and checks that
+// the stack, error, gas used and trace are the same. NaN values are equal to
+// each other, as their payload is not specified.
+func sameAsExecute(t *testing.T, code op.ByteCode, opts ...vmopt.Option) {
+	t.Helper()
+
+	program, err := gotvm.Compile(code)
+	require.NoError(t, err)
+
+	want, wantTrace := gotvm.New(opts...), &vmtest.Recorder{Depth: traceDepth}
+	want.Tracer = wantTrace
+	wantErr := want.Execute(code)
+
+	got, gotTrace := gotvm.New(opts...), &vmtest.Recorder{Depth: traceDepth}
+	got.Tracer = gotTrace
+	gotErr := program.Run(got)
+
+	var wantRuntime, gotRuntime vmerr.RuntimeError
+	if errors.As(wantErr, &wantRuntime) && errors.As(gotErr, &gotRuntime) {
+		assert.True(t, vmtest.EqualStacks(wantRuntime.Stack, gotRuntime.Stack),
+			"error stack for %v: got %v, want %v", code, gotRuntime.Stack, wantRuntime.Stack)
+		wantRuntime.Stack, gotRuntime.Stack = nil, nil
+		assert.Equal(t, wantRuntime, gotRuntime, "error for %v", code)
+	} else {
+		assert.Equal(t, wantErr, gotErr, "error for %v", code)
+	}
+	assert.True(t, vmtest.EqualStacks(values(want.Stack), values(got.Stack)),
+		"stack for %v: got %v, want %v", code, got.Stack, want.Stack)
+	assert.Equal(t, want.GasUsed, got.GasUsed, "gas used by %v", code)
+	assert.Equal(t, wantTrace.Events, gotTrace.Events, "trace of %v", code)
+}
+
+// traceDepth is the number of values from the top of the stack traced for
+// each instruction, which keeps the traces of long programs cheap
+const traceDepth = 4
+
+// values returns the stack of a VM as it is compared by vmtest.
+func values(stack []gotvm.Value) []interface{} {
+	result := make([]interface{}, len(stack))
+	for idx, value := range stack {
+		result[idx] = value.Interface()
+	}
+	return result
+}
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		Source string
+	}{
+		{"empty", ""},
+		{"push", "PushI32 -1\nPushI64 2\nPushU32 3\nPushU64 4\nPushF32 1.5\nPushF64 -2.5"},
+		{"stack", "PushI32 1\nPushI32 2\nCopy 0\nSwap 1\nPop\nPushI32 3\nPopN 2"},
+		{"arithmetic", "PushI32 3\nPushI32 10\nSubInt\nCopy 0\nMulInt\nPushI32 7\nDivInt\nNegative\nIncrement\nDecrement"},
+		{"const", "PushI32 3\nAddConstI32 -4\nSubConstI64 2\nMulConstI32 3\nDivConstI64 2"},
+		{"const-uint", "PushU32 3\nAddConstU32 4\nSubConstU64 2\nMulConstU32 3\nDivConstU64 2"},
+		{"const-float", "PushF32 3\nAddConstF32 0.5\nSubConstF64 2\nMulConstF32 3\nDivConstF64 0"},
+		{"coerce", "PushU32 1\nPushF32 2.5\nAddInt\nAddConstU32 1\nAddConstF64 0.5"},
+		{"compare", "PushI32 -1\nPushU32 1\nLt\nPushF32 1\nPushF64 nan\nEq\nPushI32 1\nPushI32 1\nNe\nPushU32 2\nPushI32 1\nCmp"},
+		{"compare-all", "PushI32 1\nPushI32 2\nLe\nPushI32 1\nPushI32 2\nGt\nPushI32 1\nPushI32 2\nGe"},
+		{
+			"jumps",
+			"PushI32 0\nJumpIfZero a\nHalt\na:\nPushU32 1\nJumpIfNotZeroUint b\nHalt\nb:\nPushF32 0\nJumpIfZeroFloat c\nHalt\nc:\n" +
+				"PushI32 0\nJumpIfNotZeroInt d\nPushU32 0\nJumpIfZeroUint e\nd:\nHalt\ne:\nPushF32 1\nJumpIfNotZeroFloat f\nHalt\nf:\n" +
+				"PushI32 0\nJumpIfZeroInt g\nHalt\ng:\nPushI32 1\nJumpIfNotZero h\nHalt\nh:\nJump end\nPushI32 9\nend:",
+		},
+		{"loop", "PushI32 10\nloop:\nDecrement\nCopy 0\nJumpIfNotZero loop"},
+		{"call", "PushI32 7\nPushI32 2\nCall f 1\nHalt\nf:\nCopy 0\nCall g 1\nReturn 2\ng:\nMulConstI32 3\nReturn 1"},
+		{"return-top-level", "PushI32 1\nPushI32 2\nReturn 1\nPushI32 3"},
+		{"call-to-end", "Call end 0\nend:"},
+		{"native", "PushI32 0\nPushI32 1\nPushF32 2\nNativeCall 0 2\nAddConstI32 1"},
+		{"missing-native", "NativeCall 1 0"},
+		{"invalid-type", "PushF32 1\nPushI32 1\nEq"},
+		{"div-zero", "PushI32 0\nPushI32 1\nDivInt"},
+		{"div-const-zero", "PushU32 1\nDivConstU32 0"},
+		{"overflow", "PushI64 -9223372036854775808\nDivConstI32 -1"},
+		{"overflow-int", "PushI32 -1\nPushI64 -9223372036854775808\nDivInt"},
+		{"error-in-function", "PushI32 1\nCall f 1\nHalt\nf:\nDivConstI32 0"},
+		{"stack-overflow", "PushI32 1\nPushI32 2\nPushI32 3\nCopy 0\nPushI32 5"},
+		{"recursion", "f:\nCall f 0"},
+		{"out-of-gas", "loop:\nNoop\nJump loop"},
+	}
+
+	for _, test := range tests {
+		test := test
+		for config, opts := range compiledConfigs {
+			// The gas limit stops the programs which never halt
+			opts := append([]vmopt.Option{vmopt.WithGasLimit(compileSteps)}, opts...)
+			t.Run(test.Name+"/"+config, func(t *testing.T) {
+				t.Parallel()
+				sameAsExecute(t, vmtest.Assemble(t, test.Source), opts...)
+			})
+		}
+	}
+}
+
+func TestCompileCorpus(t *testing.T) {
+	t.Parallel()
+
+	for name, code := range vmtest.Corpus(t) {
+		code := code
+		for config, opts := range compiledConfigs {
+			opts := opts
+			t.Run(name+"/"+config, func(t *testing.T) {
+				t.Parallel()
+				sameAsExecute(t, code, opts...)
+			})
+		}
+	}
+}
+
+func TestCompileRejected(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Code     op.ByteCode
+		Expected error
+	}{
+		{"too-few-values", op.ByteCode{op.PushInt32, 1, op.AddInt}, vmerr.ErrTooFewValues},
+		{"invalid-target", op.ByteCode{op.Jump, 5}, verify.ErrInvalidTarget},
+		{"invalid-opcode", op.ByteCode{op.Op(0xDEAD)}, vmerr.ErrInvalidOpcode},
+		{"missing-operand", op.ByteCode{op.PushInt64, 1}, vmerr.ErrMissingConstArg},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			program, err := gotvm.Compile(test.Code)
+			assert.ErrorIs(t, err, test.Expected)
+			var verr verify.Error
+			assert.True(t, errors.As(err, &verr))
+			assert.Nil(t, program)
+		})
+	}
+}
+
+func TestProgramReuse(t *testing.T) {
+	t.Parallel()
+
+	code := op.ByteCode{op.PushInt32, 3, op.Call, 4, 1, op.Halt, op.MulConstInt32, 2, op.Return, 1}
+	program, err := gotvm.Compile(code)
+	require.NoError(t, err)
+
+	// Changing the code afterwards does not change the program
+	code[1] = 5
+
+	vm := gotvm.New()
+	for i := 0; i < 2; i++ {
+		vm.Stack = vm.Stack[:0]
+		require.NoError(t, program.Run(vm))
+		assert.Equal(t, []gotvm.Value{gotvm.Int(6)}, vm.Stack)
+		assert.Empty(t, vm.Frames)
+		assert.Equal(t, uint64(9), vm.GasUsed)
+	}
+	other := gotvm.New()
+	require.NoError(t, program.Run(other))
+	assert.Equal(t, []gotvm.Value{gotvm.Int(6)}, other.Stack)
+}
+
+func TestProgramRunContext(t *testing.T) {
+	t.Parallel()
+
+	loop, err := gotvm.Compile(op.ByteCode{op.Noop, op.Jump, 0xFFFFFFFF})
+	require.NoError(t, err)
+	push, err := gotvm.Compile(op.ByteCode{op.PushInt32, 1, op.Halt})
+	require.NoError(t, err)
+
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		vm := gotvm.New()
+		err := push.RunContext(ctx, vm)
+		assert.Equal(t, vmerr.InterruptedError{OpCode: "PushI32", Offset: 0, Err: context.Canceled}, errors.Unwrap(err))
+		assert.Empty(t, vm.Stack)
+	})
+	t.Run("deadline", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := loop.RunContext(ctx, gotvm.New())
+		assert.ErrorIs(t, err, vmerr.ErrInterrupted)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestProgramStackTrace(t *testing.T) {
+	t.Parallel()
+
+	source := []string{
+		"    PushI32 7",
+		"    PushI32 1",
+		"    Call outer 1",
+		"    Halt",
+		"outer:",
+		"    PushI32 2",
+		"    Call inner 1",
+		"    Return 1",
+		"inner:",
+		"    DivConstI32 0",
+	}
+	code, debug := assembler.AssembleDebug(source, nil)
+	program, err := gotvm.Compile(code)
+	require.NoError(t, err)
+
+	err = program.Run(gotvm.New(vmopt.WithDebugInfo(debug)))
+	assert.ErrorIs(t, err, vmerr.ErrDivisionByZero)
+	assert.Equal(t, []vmerr.Frame{
+		{Function: "inner", Entry: 15, CallSite: 10, FrameBase: 2},
+		{Function: "outer", Entry: 8, CallSite: 4, FrameBase: 1},
+		{Function: "", Entry: 0, CallSite: -1, FrameBase: 0},
+	}, vmerr.StackTrace(err))
+}
+
+func TestProgramNoTracerAllocations(t *testing.T) {
+	program, err := gotvm.Compile(op.ByteCode{op.PushInt32, 1000, op.Decrement, op.Copy, 0, op.JumpIfNotZero, 0xFFFFFFFD})
+	require.NoError(t, err)
+	vm := gotvm.New()
+	allocs := testing.AllocsPerRun(10, func() {
+		vm.Stack = vm.Stack[:0]
+		_ = program.Run(vm)
+	})
+	assert.Zero(t, allocs)
+}
+
+// compileSteps is the gas limit for programs run by FuzzCompile, so that
+// every program finishes
+const compileSteps = 10000
+
+func FuzzCompile(f *testing.F) {
+	for _, code := range vmtest.Corpus(f) {
+		f.Add(vmtest.BytesFromCode(code), false)
+	}
+	f.Add([]byte{}, true)
+
+	f.Fuzz(func(t *testing.T, data []byte, strict bool) {
+		code := vmtest.CodeFromBytes(data)
+		if _, err := verify.Verify(code); err != nil {
+			return
+		}
+		sameAsExecute(t, code, vmopt.WithGasLimit(compileSteps), vmopt.WithStrict(strict))
+	})
+}
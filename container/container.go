@@ -0,0 +1,327 @@
+// Package container implements the binary file format used to save assembled
+// programs.
+//
+// A container holds the bytecode of a program together with its constants
+// and, optionally, the debug info from the assembler, so that a program can
+// be assembled once and shipped without its source. All values are stored
+// little-endian.
+//
+// The layout of a container is:
+//
+//	offset  size  field
+//	0       4     magic, the bytes "GTVM"
+//	4       2     instruction set version, op.Version
+//	6       2     flags
+//	8       4     number of sections
+//	12      ...   sections
+//	end-4   4     CRC-32 (IEEE) of every byte before it
+//
+// Each section is a Section id (4 bytes) and the size of its payload in
+// bytes (4 bytes), followed by the payload. The payloads are:
+//
+//	SectionCode       each Op as 4 bytes
+//	SectionConstants  each constant as 8 bytes
+//	SectionSymbols    each symbol as its offset (4 bytes), the length of its
+//	                  name (4 bytes) and the name
+//	SectionLines      each line as its offset (4 bytes) and line (4 bytes)
+//
+// Every container has exactly one code section; the other sections appear at
+// most once. Symbol and line entries are sorted by offset.
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/vmerr"
+)
+
+const (
+	ErrInvalidMagic       = vmerr.ConstError("not a bytecode container")
+	ErrUnsupportedVersion = vmerr.ConstError("unsupported instruction set version")
+	ErrUnsupportedFlags   = vmerr.ConstError("unsupported container flags")
+	ErrChecksum           = vmerr.ConstError("container checksum mismatch")
+	ErrTruncated          = vmerr.ConstError("container is truncated")
+	ErrInvalidSection     = vmerr.ConstError("invalid container section")
+)
+
+// Magic is the first four bytes of every container.
+const Magic = "GTVM"
+
+// headerSize is the size of the fixed fields before the sections, and
+// checksumSize the size of the checksum after them.
+const (
+	headerSize   = 12
+	checksumSize = 4
+)
+
+// Flags describe the contents of a container.
+type Flags uint16
+
+const (
+	// FlagDebug is set if the container holds debug info.
+	FlagDebug Flags = 1 << iota
+
+	// knownFlags are the flags this version of the package understands.
+	knownFlags = FlagDebug
+)
+
+// Section identifies a section of a container.
+type Section uint32
+
+const (
+	SectionCode      Section = iota + 1 // The bytecode of the program
+	SectionConstants                    // The constant table
+	SectionSymbols                      // The DebugInfo symbols
+	SectionLines                        // The DebugInfo lines
+)
+
+// Program is the content of a container.
+type Program struct {
+	Code op.ByteCode
+
+	// Constants is a table of constants carried with the program. The current
+	// instruction set encodes constants in the bytecode itself, so it is empty
+	// for programs from the assembler.
+	Constants []uint64
+
+	// Debug is the debug info of the program, or nil if it has none.
+	Debug *op.DebugInfo
+}
+
+// Encode writes the program to w as a container.
+//
+// An error is returned if a debug info offset is outside of the code, or if
+// writing to w fails.
+func (p *Program) Encode(w io.Writer) error {
+	flags := Flags(0)
+	sections := uint32(1)
+	if len(p.Constants) > 0 {
+		sections++
+	}
+	if p.Debug != nil {
+		flags |= FlagDebug
+		sections += 2
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(Magic)
+	writeU16(buf, op.Version)
+	writeU16(buf, uint16(flags))
+	writeU32(buf, sections)
+
+	code := make([]byte, 0, len(p.Code)*4)
+	for _, value := range p.Code {
+		code = binary.LittleEndian.AppendUint32(code, uint32(value))
+	}
+	writeSection(buf, SectionCode, code)
+
+	if len(p.Constants) > 0 {
+		constants := make([]byte, 0, len(p.Constants)*8)
+		for _, value := range p.Constants {
+			constants = binary.LittleEndian.AppendUint64(constants, value)
+		}
+		writeSection(buf, SectionConstants, constants)
+	}
+
+	if p.Debug != nil {
+		var symbols []byte
+		for _, offset := range sortedKeys(p.Debug.Symbols) {
+			if offset < 0 || offset > len(p.Code) {
+				return fmt.Errorf("%w: symbol offset %d is outside of the code", ErrInvalidSection, offset)
+			}
+			name := p.Debug.Symbols[offset]
+			symbols = binary.LittleEndian.AppendUint32(symbols, uint32(offset))
+			symbols = binary.LittleEndian.AppendUint32(symbols, uint32(len(name)))
+			symbols = append(symbols, name...)
+		}
+		writeSection(buf, SectionSymbols, symbols)
+
+		var lines []byte
+		for _, offset := range sortedKeys(p.Debug.Lines) {
+			line := p.Debug.Lines[offset]
+			if offset < 0 || offset > len(p.Code) {
+				return fmt.Errorf("%w: line offset %d is outside of the code", ErrInvalidSection, offset)
+			}
+			if line < 0 || int64(line) > math.MaxUint32 {
+				return fmt.Errorf("%w: line %d is out of range", ErrInvalidSection, line)
+			}
+			lines = binary.LittleEndian.AppendUint32(lines, uint32(offset))
+			lines = binary.LittleEndian.AppendUint32(lines, uint32(line))
+		}
+		writeSection(buf, SectionLines, lines)
+	}
+
+	writeU32(buf, crc32.ChecksumIEEE(buf.Bytes()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Decode reads a container from r.
+//
+// The whole of r is read; an error is returned if it is not a valid
+// container, if it was written for a different instruction set version, or if
+// its checksum does not match.
+func Decode(r io.Reader) (*Program, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(Magic) || string(data[:len(Magic)]) != Magic {
+		return nil, ErrInvalidMagic
+	}
+	if len(data) < headerSize+checksumSize {
+		return nil, fmt.Errorf("%w: %d bytes is too short for the header", ErrTruncated, len(data))
+	}
+
+	body, sum := data[:len(data)-checksumSize], binary.LittleEndian.Uint32(data[len(data)-checksumSize:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrChecksum
+	}
+	if version := binary.LittleEndian.Uint16(body[4:]); version != op.Version {
+		return nil, fmt.Errorf("%w: got %d, expected %d", ErrUnsupportedVersion, version, op.Version)
+	}
+	flags := Flags(binary.LittleEndian.Uint16(body[6:]))
+	if flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("%w: 0x%04x", ErrUnsupportedFlags, uint16(flags))
+	}
+
+	p := &Program{}
+	if flags&FlagDebug != 0 {
+		p.Debug = op.NewDebugInfo()
+	}
+	count := binary.LittleEndian.Uint32(body[8:])
+	rest := body[headerSize:]
+	seen := map[Section]bool{}
+	for n := uint32(0); n < count; n++ {
+		if len(rest) < 8 {
+			return nil, fmt.Errorf("%w: section %d has no header", ErrTruncated, n)
+		}
+		id := Section(binary.LittleEndian.Uint32(rest))
+		size := binary.LittleEndian.Uint32(rest[4:])
+		rest = rest[8:]
+		if uint64(size) > uint64(len(rest)) {
+			return nil, fmt.Errorf("%w: section %d needs %d bytes, %d left", ErrTruncated, id, size, len(rest))
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("%w: duplicate section %d", ErrInvalidSection, id)
+		}
+		seen[id] = true
+		if err := p.decodeSection(id, rest[:size]); err != nil {
+			return nil, err
+		}
+		rest = rest[size:]
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after the last section", ErrInvalidSection, len(rest))
+	}
+	if !seen[SectionCode] {
+		return nil, fmt.Errorf("%w: no code section", ErrInvalidSection)
+	}
+	if p.Debug == nil && (seen[SectionSymbols] || seen[SectionLines]) {
+		return nil, fmt.Errorf("%w: debug section without the debug flag", ErrInvalidSection)
+	}
+	if p.Debug != nil {
+		for offset := range p.Debug.Symbols {
+			if offset > len(p.Code) {
+				return nil, fmt.Errorf("%w: symbol offset %d is outside of the code", ErrInvalidSection, offset)
+			}
+		}
+		for offset := range p.Debug.Lines {
+			if offset > len(p.Code) {
+				return nil, fmt.Errorf("%w: line offset %d is outside of the code", ErrInvalidSection, offset)
+			}
+		}
+	}
+	return p, nil
+}
+
+// decodeSection decodes the payload of a single section into the program.
+func (p *Program) decodeSection(id Section, payload []byte) error {
+	switch id {
+	case SectionCode:
+		if len(payload)%4 != 0 {
+			return fmt.Errorf("%w: code size %d is not a multiple of 4", ErrInvalidSection, len(payload))
+		}
+		p.Code = make(op.ByteCode, len(payload)/4)
+		for idx := range p.Code {
+			p.Code[idx] = op.Op(binary.LittleEndian.Uint32(payload[idx*4:]))
+		}
+	case SectionConstants:
+		if len(payload)%8 != 0 {
+			return fmt.Errorf("%w: constants size %d is not a multiple of 8", ErrInvalidSection, len(payload))
+		}
+		if len(payload) == 0 {
+			// An empty table is not written by Encode; leave it nil so the
+			// program encodes back to the same value
+			return nil
+		}
+		p.Constants = make([]uint64, len(payload)/8)
+		for idx := range p.Constants {
+			p.Constants[idx] = binary.LittleEndian.Uint64(payload[idx*8:])
+		}
+	case SectionSymbols:
+		if p.Debug == nil {
+			return nil
+		}
+		for len(payload) > 0 {
+			if len(payload) < 8 {
+				return fmt.Errorf("%w: symbol entry", ErrTruncated)
+			}
+			offset := binary.LittleEndian.Uint32(payload)
+			size := binary.LittleEndian.Uint32(payload[4:])
+			payload = payload[8:]
+			if uint64(size) > uint64(len(payload)) {
+				return fmt.Errorf("%w: symbol name", ErrTruncated)
+			}
+			p.Debug.Symbols[int(offset)] = string(payload[:size])
+			payload = payload[size:]
+		}
+	case SectionLines:
+		if p.Debug == nil {
+			return nil
+		}
+		if len(payload)%8 != 0 {
+			return fmt.Errorf("%w: lines size %d is not a multiple of 8", ErrInvalidSection, len(payload))
+		}
+		for ; len(payload) > 0; payload = payload[8:] {
+			offset := binary.LittleEndian.Uint32(payload)
+			p.Debug.Lines[int(offset)] = int(binary.LittleEndian.Uint32(payload[4:]))
+		}
+	default:
+		return fmt.Errorf("%w: unknown section %d", ErrInvalidSection, id)
+	}
+	return nil
+}
+
+// writeSection writes a section header and its payload.
+func writeSection(buf *bytes.Buffer, id Section, payload []byte) {
+	writeU32(buf, uint32(id))
+	writeU32(buf, uint32(len(payload)))
+	buf.Write(payload)
+}
+
+func writeU16(buf *bytes.Buffer, value uint16) {
+	buf.Write(binary.LittleEndian.AppendUint16(nil, value))
+}
+
+func writeU32(buf *bytes.Buffer, value uint32) {
+	buf.Write(binary.LittleEndian.AppendUint32(nil, value))
+}
+
+// sortedKeys returns the keys of the map in increasing order.
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,209 @@
+package container_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/container"
+	"github.com/tvarney/gotvm/op"
+)
+
+// encode encodes the program, failing the test on error.
+func encode(t testing.TB, p *container.Program) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	require.NoError(t, p.Encode(buf))
+	return buf.Bytes()
+}
+
+// resum replaces the checksum at the end of the data, so that tests can
+// corrupt a container without failing the checksum.
+func resum(data []byte) []byte {
+	body := data[:len(data)-4]
+	return binary.LittleEndian.AppendUint32(append([]byte{}, body...), crc32.ChecksumIEEE(body))
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	p := &container.Program{Code: op.ByteCode{op.PushInt32, 0x01020304, op.Halt}}
+	expected := []byte{
+		'G', 'T', 'V', 'M',
+		op.Version, 0x00, // version
+		0x00, 0x00, // flags
+		0x01, 0x00, 0x00, 0x00, // sections
+		0x01, 0x00, 0x00, 0x00, // code section
+		0x0C, 0x00, 0x00, 0x00, // 12 bytes
+		byte(op.PushInt32), 0x00, 0x00, 0x00,
+		0x04, 0x03, 0x02, 0x01,
+		byte(op.Halt), 0x00, 0x00, 0x00,
+	}
+	expected = binary.LittleEndian.AppendUint32(expected, crc32.ChecksumIEEE(expected))
+	assert.Equal(t, expected, encode(t, p))
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	debug := op.NewDebugInfo()
+	debug.Symbols[0] = "main"
+	debug.Symbols[3] = "end"
+	debug.Lines[0] = 1
+	debug.Lines[2] = 4
+	code := op.ByteCode{op.PushInt32, 1, op.Halt}
+
+	tests := []struct {
+		Name    string
+		Program *container.Program
+	}{
+		{"empty", &container.Program{Code: op.ByteCode{}}},
+		{"code", &container.Program{Code: code}},
+		{"constants", &container.Program{Code: code, Constants: []uint64{1, 1 << 63}}},
+		{"debug", &container.Program{Code: code, Debug: debug}},
+		{"empty-debug", &container.Program{Code: code, Debug: op.NewDebugInfo()}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := container.Decode(bytes.NewReader(encode(t, test.Program)))
+			require.NoError(t, err)
+			assert.Equal(t, test.Program, p)
+		})
+	}
+}
+
+func TestRoundTripCorpus(t *testing.T) {
+	t.Parallel()
+
+	files, err := filepath.Glob(filepath.Join("..", "vmtest", "testdata", "*.asm"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			t.Parallel()
+
+			content, err := os.ReadFile(file)
+			require.NoError(t, err)
+			code, debug := assembler.AssembleDebug(strings.Split(string(content), "\n"), nil)
+			program := &container.Program{Code: code, Debug: debug}
+
+			p, err := container.Decode(bytes.NewReader(encode(t, program)))
+			require.NoError(t, err)
+			assert.Equal(t, program, p)
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	t.Parallel()
+
+	d := func(symbols map[int]string, lines map[int]int) *op.DebugInfo {
+		return &op.DebugInfo{Symbols: symbols, Lines: lines}
+	}
+	code := op.ByteCode{op.Halt}
+
+	tests := []struct {
+		Name  string
+		Debug *op.DebugInfo
+	}{
+		{"negative-symbol", d(map[int]string{-1: "a"}, nil)},
+		{"symbol-after-code", d(map[int]string{2: "a"}, nil)},
+		{"line-after-code", d(nil, map[int]int{2: 1})},
+		{"negative-line", d(nil, map[int]int{0: -1})},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &container.Program{Code: code, Debug: test.Debug}
+			assert.ErrorIs(t, p.Encode(&bytes.Buffer{}), container.ErrInvalidSection)
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	debug := op.NewDebugInfo()
+	debug.Symbols[0] = "main"
+	valid := encode(t, &container.Program{Code: op.ByteCode{op.Halt}, Debug: debug})
+	edit := func(fn func(data []byte) []byte) []byte {
+		return fn(append([]byte{}, valid...))
+	}
+	section := func(id container.Section, payload ...byte) []byte {
+		data := []byte(container.Magic)
+		data = append(data, op.Version, 0, 0, 0, 1, 0, 0, 0)
+		data = binary.LittleEndian.AppendUint32(data, uint32(id))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(payload)))
+		data = append(data, payload...)
+		return resum(append(data, 0, 0, 0, 0))
+	}
+
+	tests := []struct {
+		Name          string
+		Data          []byte
+		ExpectedError error
+	}{
+		{"empty", nil, container.ErrInvalidMagic},
+		{"magic", []byte("ELF\x7F"), container.ErrInvalidMagic},
+		{"short-header", []byte("GTVM\x01\x00"), container.ErrTruncated},
+		{"checksum", edit(func(d []byte) []byte { d[len(d)-1] ^= 0xFF; return d }), container.ErrChecksum},
+		{"corrupt-code", edit(func(d []byte) []byte { d[20] ^= 0xFF; return d }), container.ErrChecksum},
+		{"version", edit(func(d []byte) []byte { d[4]++; return resum(d) }), container.ErrUnsupportedVersion},
+		{"flags", edit(func(d []byte) []byte { d[7] = 0x80; return resum(d) }), container.ErrUnsupportedFlags},
+		{"no-debug-flag", edit(func(d []byte) []byte { d[6] = 0; return resum(d) }), container.ErrInvalidSection},
+		{"too-few-sections", edit(func(d []byte) []byte { d[8]--; return resum(d) }), container.ErrInvalidSection},
+		{"too-many-sections", edit(func(d []byte) []byte { d[8]++; return resum(d) }), container.ErrTruncated},
+		{"section-size", edit(func(d []byte) []byte { d[16] = 0xFF; return resum(d) }), container.ErrTruncated},
+		{"no-code", section(container.SectionConstants), container.ErrInvalidSection},
+		{"unknown-section", section(container.Section(99)), container.ErrInvalidSection},
+		{"partial-op", section(container.SectionCode, 1, 0), container.ErrInvalidSection},
+		{"partial-constant", section(container.SectionConstants, 1, 0, 0, 0), container.ErrInvalidSection},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := container.Decode(bytes.NewReader(test.Data))
+			assert.ErrorIs(t, err, test.ExpectedError)
+			assert.Nil(t, p)
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	debug := op.NewDebugInfo()
+	debug.Symbols[0] = "main"
+	debug.Lines[0] = 1
+	f.Add(encode(f, &container.Program{Code: op.ByteCode{op.PushInt32, 1, op.Halt}, Debug: debug}))
+	f.Add(encode(f, &container.Program{Code: op.ByteCode{}, Constants: []uint64{1}}))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := container.Decode(bytes.NewReader(data))
+		if err != nil {
+			assert.Nil(t, p)
+			return
+		}
+		p2, err := container.Decode(bytes.NewReader(encode(t, p)))
+		require.NoError(t, err)
+		assert.Equal(t, p, p2)
+	})
+}
@@ -0,0 +1,142 @@
+// Package disassembler turns bytecode back into assembly text.
+//
+// The text produced is accepted by the assembler package and assembles to
+// the same bytecode, so a program can be disassembled, edited and assembled
+// again.
+package disassembler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/op"
+)
+
+// Indent is the prefix of every instruction line; label lines are not
+// indented.
+const Indent = "    "
+
+// Disassemble decodes the bytecode into lines of assembly, one instruction per
+// line.
+//
+// If debug info is given, each symbol at the start of an instruction is
+// written as a label, and offset operands which land on a label name it
+// instead of giving the literal offset. Symbols which are not valid label
+// names are ignored, as are repeated names after the first.
+//
+// An error is returned if the bytecode has an invalid opcode or ends before
+// the operands of an instruction; the error wraps the vmerr error from
+// op.Decode.
+func Disassemble(code op.ByteCode, debug *op.DebugInfo) ([]string, error) {
+	insts := make([]op.Instruction, 0, len(code))
+	starts := make(map[int]bool, len(code)+1)
+	for offset := 0; offset < len(code); {
+		inst, err := op.Decode(code, offset)
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", offset, err)
+		}
+		insts = append(insts, inst)
+		starts[offset] = true
+		offset = inst.Next()
+	}
+	starts[len(code)] = true
+
+	labels := labels(debug, starts)
+	lines := make([]string, 0, len(insts)+len(labels))
+	for _, inst := range insts {
+		if name, ok := labels[inst.Offset]; ok {
+			lines = append(lines, name+":")
+		}
+		lines = append(lines, Indent+Format(inst, labels))
+	}
+	if name, ok := labels[len(code)]; ok {
+		lines = append(lines, name+":")
+	}
+	return lines, nil
+}
+
+// labels returns the symbols of the debug info which can be written as labels,
+// keyed by offset.
+func labels(debug *op.DebugInfo, starts map[int]bool) map[int]string {
+	if debug == nil {
+		return nil
+	}
+	offsets := make([]int, 0, len(debug.Symbols))
+	for offset := range debug.Symbols {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	labels := make(map[int]string, len(offsets))
+	seen := make(map[string]bool, len(offsets))
+	for _, offset := range offsets {
+		name := debug.Symbols[offset]
+		if !starts[offset] || seen[name] || !assembler.IsLabel(name) {
+			continue
+		}
+		labels[offset] = name
+		seen[name] = true
+	}
+	return labels
+}
+
+// Format returns the assembly of a single instruction. Offset operands which
+// land on an offset in labels are written as the label name.
+func Format(inst op.Instruction, labels map[int]string) string {
+	meta, ok := op.Info(inst.Op)
+	if !ok {
+		return fmt.Sprintf("<invalid opcode 0x%08x>", uint32(inst.Op))
+	}
+
+	var b strings.Builder
+	b.WriteString(meta.Mnemonic)
+	for n, operand := range meta.Operands {
+		var value uint64
+		if n < len(inst.Args) {
+			value = inst.Args[n]
+		}
+		b.WriteByte(' ')
+		if operand == op.OperandOffset {
+			if name, ok := labels[inst.Offset+int(int32(uint32(value)))]; ok {
+				b.WriteString(name)
+				continue
+			}
+		}
+		b.WriteString(FormatOperand(operand, value))
+	}
+	return b.String()
+}
+
+// FormatOperand returns the assembly of the raw value of an operand.
+//
+// Floats are written in the shortest form which parses back to the same
+// value; values which can't be written that way (e.g. NaN payloads) are
+// written as their raw bits in hex.
+func FormatOperand(operand op.Operand, value uint64) string {
+	switch operand {
+	case op.OperandInt32, op.OperandOffset:
+		return strconv.FormatInt(int64(int32(uint32(value))), 10)
+	case op.OperandInt64:
+		return strconv.FormatInt(int64(value), 10)
+	case op.OperandUint32:
+		return strconv.FormatUint(uint64(uint32(value)), 10)
+	case op.OperandFloat32:
+		bits := uint32(value)
+		text := strconv.FormatFloat(float64(math.Float32frombits(bits)), 'g', -1, 32)
+		if f, err := strconv.ParseFloat(text, 32); err == nil && math.Float32bits(float32(f)) == bits {
+			return text
+		}
+		return fmt.Sprintf("0x%08X", bits)
+	case op.OperandFloat64:
+		text := strconv.FormatFloat(math.Float64frombits(value), 'g', -1, 64)
+		if f, err := strconv.ParseFloat(text, 64); err == nil && math.Float64bits(f) == value {
+			return text
+		}
+		return fmt.Sprintf("0x%016X", value)
+	}
+	return strconv.FormatUint(value, 10)
+}
@@ -0,0 +1,184 @@
+package disassembler_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/disassembler"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+func TestDisassemble(t *testing.T) {
+	t.Parallel()
+
+	b := func(values ...op.Op) op.ByteCode {
+		return values
+	}
+	d := func(symbols map[int]string) *op.DebugInfo {
+		debug := op.NewDebugInfo()
+		debug.Symbols = symbols
+		return debug
+	}
+	f32 := func(f float32) op.Op {
+		return op.Op(math.Float32bits(f))
+	}
+	f64 := func(f float64) (op.Op, op.Op) {
+		bits := math.Float64bits(f)
+		return op.Op(uint32(bits >> 32)), op.Op(uint32(bits))
+	}
+	hi, lo := f64(-2.5)
+
+	tests := []struct {
+		Name     string
+		Code     op.ByteCode
+		Debug    *op.DebugInfo
+		Expected []string
+	}{
+		{"empty", nil, nil, []string{}},
+		{"no-operands", b(op.Noop, op.Halt), nil, []string{"    Noop", "    Halt"}},
+		{"int32", b(op.PushInt32, 0xFFFFFFFF), nil, []string{"    PushI32 -1"}},
+		{"min-int32", b(op.PushInt32, 0x80000000), nil, []string{"    PushI32 -2147483648"}},
+		{"int64", b(op.PushInt64, 0xFFFFFFFF, 0xFFFFFFFE), nil, []string{"    PushI64 -2"}},
+		{"uint32", b(op.PushUint32, 0xFFFFFFFF), nil, []string{"    PushU32 4294967295"}},
+		{"uint64", b(op.PushUint64, 1, 0), nil, []string{"    PushU64 4294967296"}},
+		{"float32", b(op.PushFloat32, f32(10.5)), nil, []string{"    PushF32 10.5"}},
+		{"float32-inf", b(op.PushFloat32, f32(float32(math.Inf(-1)))), nil, []string{"    PushF32 -Inf"}},
+		{"float32-nan", b(op.PushFloat32, 0x7FC00001), nil, []string{"    PushF32 0x7FC00001"}},
+		{"float64", b(op.PushFloat64, hi, lo), nil, []string{"    PushF64 -2.5"}},
+		{"float64-nan", b(op.PushFloat64, 0x7FF80000, 2), nil, []string{"    PushF64 0x7FF8000000000002"}},
+		{"offset", b(op.Noop, op.Jump, 0xFFFFFFFF), nil, []string{"    Noop", "    Jump -1"}},
+		{
+			"labels",
+			b(op.Noop, op.Jump, 0, op.Call, 3, 0),
+			d(map[int]string{1: "loop", 6: "end"}),
+			[]string{"    Noop", "loop:", "    Jump loop", "    Call end 0", "end:"},
+		},
+		{
+			"label-inside-instruction",
+			b(op.PushInt32, 1, op.Jump, 0xFFFFFFFF),
+			d(map[int]string{1: "operand"}),
+			[]string{"    PushI32 1", "    Jump -1"},
+		},
+		{
+			"invalid-label",
+			b(op.Jump, 0),
+			d(map[int]string{0: "1abc"}),
+			[]string{"    Jump 0"},
+		},
+		{
+			"duplicate-label",
+			b(op.Noop, op.Jump, 0xFFFFFFFF),
+			d(map[int]string{0: "same", 1: "same"}),
+			[]string{"same:", "    Noop", "    Jump same"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			lines, err := disassembler.Disassemble(test.Code, test.Debug)
+			require.NoError(t, err)
+			assert.Equal(t, test.Expected, lines)
+			assert.Equal(t, test.Code, assemble(t, lines))
+		})
+	}
+}
+
+func TestDisassembleErrors(t *testing.T) {
+	t.Parallel()
+
+	b := func(values ...op.Op) op.ByteCode {
+		return values
+	}
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		ExpectedError error
+	}{
+		{"invalid-opcode", b(op.Noop, op.Op(0xDEAD)), vmerr.ErrInvalidOpcode},
+		{"truncated", b(op.Noop, op.PushInt64, 1), vmerr.ErrMissingConstArg},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			lines, err := disassembler.Disassemble(test.Code, nil)
+			assert.ErrorIs(t, err, test.ExpectedError)
+			assert.ErrorContains(t, err, "offset 1")
+			assert.Nil(t, lines)
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*.asm"))
+	require.NoError(t, err)
+	corpus, err := filepath.Glob(filepath.Join("..", "vmtest", "testdata", "*.asm"))
+	require.NoError(t, err)
+	files = append(files, corpus...)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			t.Parallel()
+
+			content, err := os.ReadFile(file)
+			require.NoError(t, err)
+			code, debug := assembler.AssembleDebug(strings.Split(string(content), "\n"), nil)
+			require.NotEmpty(t, code)
+
+			for _, info := range []*op.DebugInfo{nil, debug} {
+				lines, err := disassembler.Disassemble(code, info)
+				require.NoError(t, err)
+				assert.Equal(t, code, assemble(t, lines))
+			}
+		})
+	}
+}
+
+func FuzzDisassemble(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(vmtest.BytesFromCode(op.ByteCode{op.Halt}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		code := vmtest.CodeFromBytes(data)
+
+		lines, err := disassembler.Disassemble(code, nil)
+		if err != nil {
+			assert.Nil(t, lines)
+			return
+		}
+		if len(code) == 0 {
+			assert.Empty(t, lines)
+			return
+		}
+		assert.Equal(t, code, assemble(t, lines))
+	})
+}
+
+// assemble assembles the lines, failing the test on any error.
+func assemble(t *testing.T, lines []string) op.ByteCode {
+	t.Helper()
+	var errs []string
+	code := assembler.Assemble(lines, func(err assembler.AssembleError) {
+		errs = append(errs, err.Message)
+	})
+	require.Empty(t, errs, "failed to assemble:\n%s", strings.Join(lines, "\n"))
+	return code
+}
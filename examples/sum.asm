@@ -0,0 +1,24 @@
+; Sum the integers from 1 to 10 with a function call
+PushI32 10
+Call sum 1
+Halt
+
+; sum(n) -> n + (n - 1) + ... + 1
+sum:
+    PushI32 0           ; acc
+loop:
+    Copy 0              ; n
+    JumpIfZero done
+    Copy 1
+    Copy 0
+    AddInt              ; acc + n
+    Swap 1
+    Pop
+    Copy 0
+    PushI32 -1
+    AddInt              ; n - 1
+    Swap 0
+    Pop
+    Jump loop
+done:
+    Return 1            ; acc
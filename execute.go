@@ -1,6 +1,9 @@
 package gotvm
 
 import (
+	"context"
+	"math"
+
 	"github.com/tvarney/gotvm/op"
 	"github.com/tvarney/gotvm/vmerr"
 )
@@ -15,7 +18,37 @@ import (
 //
 // This is still go, so don't expect blazing fast performance.
 func (vm *VirtualMachine) Execute(code op.ByteCode) error {
+	return vm.ExecuteContext(context.Background(), code)
+}
+
+// ExecuteContext runs the ByteCode like Execute, but stops with a
+// vmerr.InterruptedError wrapping ctx.Err() if the context is done. The
+// context is checked before the first opcode and then after every
+// ContextCheckInterval opcodes.
+//
+// Errors are returned as a vmerr.RuntimeError recording where the program
+// failed.
+func (vm *VirtualMachine) ExecuteContext(ctx context.Context, code op.ByteCode) error {
+	idx, err := vm.run(ctx, code)
+	if err != nil {
+		err = vm.runtimeError(code, idx, err)
+		if vm.Tracer != nil {
+			vm.Tracer.OnError(err)
+		}
+		return err
+	}
+	if vm.Tracer != nil {
+		vm.Tracer.OnHalt((*stackView)(vm))
+	}
+	return nil
+}
+
+// run implements ExecuteContext, returning the offset of the failing
+// instruction along with any error.
+func (vm *VirtualMachine) run(ctx context.Context, code op.ByteCode) (int, error) {
 	vm.FrameBase = 0
+	vm.Frames = vm.Frames[:0]
+	vm.GasUsed = 0
 
 	// This is the hot loop and should be optimized heavily.
 	//
@@ -27,197 +60,910 @@ func (vm *VirtualMachine) Execute(code op.ByteCode) error {
 	// shorter functions which makes it slower, but this function is very
 	// likely too big for the instruction cache - making it shorter could help
 	// keep the loop resident in the instruction cache.
+	done := ctx.Done()
+	check := 1
 	idx := 0
 	for idx >= 0 && idx < len(code) {
 		opcode := code[idx]
+		if done != nil {
+			if check--; check == 0 {
+				check = ContextCheckInterval
+				select {
+				case <-done:
+					return idx, vmerr.InterruptedError{OpCode: opcode.String(), Offset: idx, Err: ctx.Err()}
+				default:
+				}
+			}
+		}
+		cost := opcode.Cost()
+		if vm.GasLimit != 0 && vm.GasUsed+cost > vm.GasLimit {
+			return idx, vmerr.OutOfGasError{OpCode: opcode.String(), Offset: idx}
+		}
+		vm.GasUsed += cost
+		if vm.Tracer != nil {
+			vm.Tracer.OnInstruction(idx, opcode, (*stackView)(vm))
+		}
 		switch opcode {
 		case op.Noop:
-			idx++
+			idx += opcode.Size()
 		case op.Halt:
-			return nil
+			return idx, nil
 		case op.PushInt32:
 			v, err := op.ConstArgU32(code, idx+1)
 			if err != nil {
-				return vmerr.MissingConstArgError{OpCode: "PushInt32"}
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if vm.full() {
+				return idx, vmerr.StackOverflowError{OpCode: opcode.String()}
 			}
-			vm.push(int64(int32(v)))
-			idx += 2
+			vm.push(Int(int64(int32(v))))
+			idx += opcode.Size()
 		case op.PushInt64:
 			v, err := op.ConstArgU64(code, idx+1)
 			if err != nil {
-				return vmerr.MissingConstArgError{OpCode: "PushInt64"}
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
 			}
-			vm.push(int64(v))
-			idx += 3
+			if vm.full() {
+				return idx, vmerr.StackOverflowError{OpCode: opcode.String()}
+			}
+			vm.push(Int(int64(v)))
+			idx += opcode.Size()
 		case op.PushUint32:
 			v, err := op.ConstArgU32(code, idx+1)
 			if err != nil {
-				return vmerr.MissingConstArgError{OpCode: "PushUint32"}
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if vm.full() {
+				return idx, vmerr.StackOverflowError{OpCode: opcode.String()}
 			}
-			vm.push(uint64(v))
-			idx += 2
+			vm.push(Uint(uint64(v)))
+			idx += opcode.Size()
 		case op.PushUint64:
 			v, err := op.ConstArgU64(code, idx+1)
 			if err != nil {
-				return vmerr.MissingConstArgError{OpCode: "PushUint64"}
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
 			}
-			vm.push(uint64(v))
-			idx += 3
+			if vm.full() {
+				return idx, vmerr.StackOverflowError{OpCode: opcode.String()}
+			}
+			vm.push(Uint(v))
+			idx += opcode.Size()
+		case op.PushFloat32:
+			v, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if vm.full() {
+				return idx, vmerr.StackOverflowError{OpCode: opcode.String()}
+			}
+			vm.push(Float(float64(math.Float32frombits(v))))
+			idx += opcode.Size()
+		case op.PushFloat64:
+			v, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if vm.full() {
+				return idx, vmerr.StackOverflowError{OpCode: opcode.String()}
+			}
+			vm.push(Float(math.Float64frombits(v)))
+			idx += opcode.Size()
 		case op.Pop:
-			if len(vm.Stack) <= 0 || idx < 0 {
-				return vmerr.TooFewValuesError{OpCode: "Pop"}
+			if len(vm.Stack) <= vm.FrameBase || idx < 0 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
 			vm.Stack = vm.Stack[:len(vm.Stack)-1]
-			idx++
+			idx += opcode.Size()
 		case op.PopN:
 			v, err := op.ConstArgU32(code, idx+1)
 			if err != nil {
-				return vmerr.MissingConstArgError{OpCode: "PopN"}
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
 			}
 			last := len(vm.Stack) - int(v)
-			if last < 0 || last >= len(vm.Stack) {
-				return vmerr.TooFewValuesError{OpCode: "PopN"}
+			if last < vm.FrameBase || last >= len(vm.Stack) {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
 			vm.Stack = vm.Stack[:last]
-			idx += 2
+			idx += opcode.Size()
 		case op.Copy:
 			v, err := op.ConstArgU32(code, idx+1)
 			if err != nil {
-				return vmerr.MissingConstArgError{OpCode: "Copy"}
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
 			}
 			ref := vm.FrameBase + int(v)
 			if ref >= len(vm.Stack) || ref < 0 {
-				return vmerr.IndexOutOfBoundsError{OpCode: "Copy"}
+				return idx, vmerr.IndexOutOfBoundsError{OpCode: opcode.String()}
+			}
+			if vm.full() {
+				return idx, vmerr.StackOverflowError{OpCode: opcode.String()}
 			}
 			vm.push(vm.Stack[ref])
-			idx += 2
+			idx += opcode.Size()
 		case op.Swap:
 			v, err := op.ConstArgU32(code, idx+1)
 			if err != nil {
-				return vmerr.MissingConstArgError{OpCode: "Swap"}
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
 			}
 			ref := vm.FrameBase + int(v)
 			if ref >= len(vm.Stack) || ref < 0 {
-				return vmerr.IndexOutOfBoundsError{OpCode: "Swap"}
+				return idx, vmerr.IndexOutOfBoundsError{OpCode: opcode.String()}
 			}
 			last := len(vm.Stack) - 1
-			if last <= 0 {
-				return vmerr.IndexOutOfBoundsError{OpCode: "Swap"}
+			if last < 0 {
+				return idx, vmerr.IndexOutOfBoundsError{OpCode: opcode.String()}
 			}
 			vm.Stack[ref], vm.Stack[last] = vm.Stack[last], vm.Stack[ref]
-			idx += 2
+			idx += opcode.Size()
 		case op.Negative:
 			if len(vm.Stack) < 1 {
-				return vmerr.TooFewValuesError{OpCode: "Negative"}
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
 			iv := vm.Stack[len(vm.Stack)-1]
 			vm.Stack = vm.Stack[:len(vm.Stack)-1]
-			switch v := iv.(type) {
-			case float64:
-				vm.Stack = append(vm.Stack, -v)
-			case uint64:
-				vm.Stack = append(vm.Stack, uint64(-int64(v)))
-			case int64:
-				vm.Stack = append(vm.Stack, -v)
+			switch iv.kind {
+			case KindFloat:
+				vm.Stack = append(vm.Stack, Float(-math.Float64frombits(iv.bits)))
+			case KindUint:
+				vm.Stack = append(vm.Stack, Uint(-iv.bits))
+			case KindInt:
+				vm.Stack = append(vm.Stack, Int(-int64(iv.bits)))
 			default:
-				return vmerr.InvalidTypeError{OpCode: "Negative"}
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
-			idx++
+			idx += opcode.Size()
 		case op.AddInt:
 			if len(vm.Stack) < 2 {
-				return vmerr.TooFewValuesError{OpCode: "AddInt"}
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
-			v1, err := coerceInt(vm.Stack[len(vm.Stack)-1])
-			if err != nil {
-				return err
+			v1, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
-			v2, err := coerceInt(vm.Stack[len(vm.Stack)-2])
-			if err != nil {
-				return err
+			v2, ok := vm.toInt(vm.Stack[len(vm.Stack)-2])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
 			vm.Stack = vm.Stack[:len(vm.Stack)-2]
-			vm.Stack = append(vm.Stack, v1+v2)
-			idx++
+			vm.Stack = append(vm.Stack, Int(v1+v2))
+			idx += opcode.Size()
 		case op.SubInt:
 			if len(vm.Stack) < 2 {
-				return vmerr.TooFewValuesError{OpCode: "SubInt"}
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
-			v1, err := coerceInt(vm.Stack[len(vm.Stack)-1])
-			if err != nil {
-				return err
+			v1, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
-			v2, err := coerceInt(vm.Stack[len(vm.Stack)-2])
-			if err != nil {
-				return err
+			v2, ok := vm.toInt(vm.Stack[len(vm.Stack)-2])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
 			vm.Stack = vm.Stack[:len(vm.Stack)-2]
-			vm.Stack = append(vm.Stack, v1-v2)
-			idx++
+			vm.Stack = append(vm.Stack, Int(v1-v2))
+			idx += opcode.Size()
 		case op.MulInt:
 			if len(vm.Stack) < 2 {
-				return vmerr.TooFewValuesError{OpCode: "MulInt"}
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
-			v1, err := coerceInt(vm.Stack[len(vm.Stack)-1])
-			if err != nil {
-				return err
+			v1, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
-			v2, err := coerceInt(vm.Stack[len(vm.Stack)-2])
-			if err != nil {
-				return err
+			v2, ok := vm.toInt(vm.Stack[len(vm.Stack)-2])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
 			vm.Stack = vm.Stack[:len(vm.Stack)-2]
-			vm.Stack = append(vm.Stack, v1*v2)
-			idx++
+			vm.Stack = append(vm.Stack, Int(v1*v2))
+			idx += opcode.Size()
 		case op.DivInt:
 			if len(vm.Stack) < 2 {
-				return vmerr.TooFewValuesError{OpCode: "DivInt"}
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v1, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
-			v1, err := coerceInt(vm.Stack[len(vm.Stack)-1])
+			v2, ok := vm.toInt(vm.Stack[len(vm.Stack)-2])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			if v2 == 0 {
+				return idx, vmerr.DivisionByZeroError{OpCode: opcode.String()}
+			}
+			if v1 == math.MinInt64 && v2 == -1 {
+				return idx, vmerr.IntegerOverflowError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(v1/v2))
+			idx += opcode.Size()
+		case op.AddConstInt32:
+			c, err := op.ConstArgI32(code, idx+1)
 			if err != nil {
-				return err
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
 			}
-			v2, err := coerceInt(vm.Stack[len(vm.Stack)-2])
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(int64(c) + v)
+			idx += opcode.Size()
+		case op.AddConstInt64:
+			c, err := op.ConstArgI64(code, idx+1)
 			if err != nil {
-				return err
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
 			}
-			vm.Stack = vm.Stack[:len(vm.Stack)-2]
-			vm.Stack = append(vm.Stack, v1/v2)
-			idx++
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(c + v)
+			idx += opcode.Size()
+		case op.AddConstUint32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(uint64(c) + v)
+			idx += opcode.Size()
+		case op.AddConstUint64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(c + v)
+			idx += opcode.Size()
+		case op.AddConstFloat32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(float64(math.Float32frombits(c)) + v)
+			idx += opcode.Size()
+		case op.AddConstFloat64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(math.Float64frombits(c) + v)
+			idx += opcode.Size()
+		case op.SubConstInt32:
+			c, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(v - int64(c))
+			idx += opcode.Size()
+		case op.SubConstInt64:
+			c, err := op.ConstArgI64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(v - c)
+			idx += opcode.Size()
+		case op.SubConstUint32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(v - uint64(c))
+			idx += opcode.Size()
+		case op.SubConstUint64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(v - c)
+			idx += opcode.Size()
+		case op.SubConstFloat32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(v - float64(math.Float32frombits(c)))
+			idx += opcode.Size()
+		case op.SubConstFloat64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(v - math.Float64frombits(c))
+			idx += opcode.Size()
+		case op.MulConstInt32:
+			c, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(int64(c) * v)
+			idx += opcode.Size()
+		case op.MulConstInt64:
+			c, err := op.ConstArgI64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(c * v)
+			idx += opcode.Size()
+		case op.MulConstUint32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(uint64(c) * v)
+			idx += opcode.Size()
+		case op.MulConstUint64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(c * v)
+			idx += opcode.Size()
+		case op.MulConstFloat32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(float64(math.Float32frombits(c)) * v)
+			idx += opcode.Size()
+		case op.MulConstFloat64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(math.Float64frombits(c) * v)
+			idx += opcode.Size()
+		case op.DivConstInt32:
+			c, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			if c == 0 {
+				return idx, vmerr.DivisionByZeroError{OpCode: opcode.String()}
+			}
+			if v == math.MinInt64 && c == -1 {
+				return idx, vmerr.IntegerOverflowError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(v / int64(c))
+			idx += opcode.Size()
+		case op.DivConstInt64:
+			c, err := op.ConstArgI64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			if c == 0 {
+				return idx, vmerr.DivisionByZeroError{OpCode: opcode.String()}
+			}
+			if v == math.MinInt64 && c == -1 {
+				return idx, vmerr.IntegerOverflowError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Int(v / c)
+			idx += opcode.Size()
+		case op.DivConstUint32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			if c == 0 {
+				return idx, vmerr.DivisionByZeroError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(v / uint64(c))
+			idx += opcode.Size()
+		case op.DivConstUint64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			if c == 0 {
+				return idx, vmerr.DivisionByZeroError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Uint(v / c)
+			idx += opcode.Size()
+		case op.DivConstFloat32:
+			c, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(v / float64(math.Float32frombits(c)))
+			idx += opcode.Size()
+		case op.DivConstFloat64:
+			c, err := op.ConstArgU64(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack[len(vm.Stack)-1] = Float(v / math.Float64frombits(c))
+			idx += opcode.Size()
 		case op.Increment:
 			if len(vm.Stack) < 1 {
-				return vmerr.TooFewValuesError{OpCode: "Increment"}
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
 			ival := vm.Stack[len(vm.Stack)-1]
 			vm.Stack = vm.Stack[:len(vm.Stack)-1]
-			switch v := ival.(type) {
-			case int64:
-				vm.Stack = append(vm.Stack, v+1)
-			case uint64:
-				vm.Stack = append(vm.Stack, v+1)
-			case float64:
-				vm.Stack = append(vm.Stack, v+1)
+			switch ival.kind {
+			case KindInt:
+				vm.Stack = append(vm.Stack, Int(int64(ival.bits)+1))
+			case KindUint:
+				vm.Stack = append(vm.Stack, Uint(ival.bits+1))
+			case KindFloat:
+				vm.Stack = append(vm.Stack, Float(math.Float64frombits(ival.bits)+1))
 			default:
-				return vmerr.InvalidTypeError{OpCode: "Increment"}
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
+			idx += opcode.Size()
 		case op.Decrement:
 			if len(vm.Stack) < 1 {
-				return vmerr.TooFewValuesError{OpCode: "Decrement"}
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
 			}
 			ival := vm.Stack[len(vm.Stack)-1]
 			vm.Stack = vm.Stack[:len(vm.Stack)-1]
-			switch v := ival.(type) {
-			case int64:
-				vm.Stack = append(vm.Stack, v-1)
-			case uint64:
-				vm.Stack = append(vm.Stack, v-1)
-			case float64:
-				vm.Stack = append(vm.Stack, v-1)
+			switch ival.kind {
+			case KindInt:
+				vm.Stack = append(vm.Stack, Int(int64(ival.bits)-1))
+			case KindUint:
+				vm.Stack = append(vm.Stack, Uint(ival.bits-1))
+			case KindFloat:
+				vm.Stack = append(vm.Stack, Float(math.Float64frombits(ival.bits)-1))
 			default:
-				return vmerr.InvalidTypeError{OpCode: "Decrement"}
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			idx += opcode.Size()
+		case op.Call:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			argc, err := op.ConstArgU32(code, idx+2)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			base := len(vm.Stack) - int(argc)
+			if base < vm.FrameBase {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			if vm.MaxFrames != 0 && len(vm.Frames) >= vm.MaxFrames {
+				return idx, vmerr.CallDepthExceededError{OpCode: opcode.String()}
+			}
+			vm.Frames = append(vm.Frames, Frame{
+				ReturnAddress: idx + opcode.Size(),
+				FrameBase:     vm.FrameBase,
+				Entry:         idx + int(offset),
+			})
+			vm.FrameBase = base
+			if vm.Tracer != nil {
+				vm.Tracer.OnCall(idx, idx+int(offset), len(vm.Frames))
+			}
+			idx += int(offset)
+		case op.Return:
+			n, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			first := len(vm.Stack) - int(n)
+			if first < vm.FrameBase {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			copy(vm.Stack[vm.FrameBase:], vm.Stack[first:])
+			vm.Stack = vm.Stack[:vm.FrameBase+int(n)]
+			if len(vm.Frames) == 0 {
+				// Returning from the top level ends the program
+				return idx, nil
+			}
+			frame := vm.Frames[len(vm.Frames)-1]
+			vm.Frames = vm.Frames[:len(vm.Frames)-1]
+			vm.FrameBase = frame.FrameBase
+			if vm.Tracer != nil {
+				vm.Tracer.OnReturn(idx, frame.ReturnAddress, len(vm.Frames))
+			}
+			idx = frame.ReturnAddress
+		case op.Jump:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			idx += int(offset)
+		case op.JumpIfZero:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			zero, ok := isZero(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if zero {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.JumpIfNotZero:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			zero, ok := isZero(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if !zero {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.JumpIfZeroInt:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if v == 0 {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.JumpIfZeroUint:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
 			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if v == 0 {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.JumpIfZeroFloat:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if v == 0 {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.JumpIfNotZeroInt:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toInt(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if v != 0 {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.JumpIfNotZeroUint:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toUint(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if v != 0 {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.JumpIfNotZeroFloat:
+			offset, err := op.ConstArgI32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if len(vm.Stack) < 1 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			v, ok := vm.toFloat(vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+			if v != 0 {
+				idx += int(offset)
+			} else {
+				idx += opcode.Size()
+			}
+		case op.Eq:
+			if len(vm.Stack) < 2 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			c, ordered, ok := vm.compare(vm.Stack[len(vm.Stack)-2], vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(boolInt(ordered && c == 0)))
+			idx += opcode.Size()
+		case op.Ne:
+			if len(vm.Stack) < 2 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			c, ordered, ok := vm.compare(vm.Stack[len(vm.Stack)-2], vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(boolInt(!ordered || c != 0)))
+			idx += opcode.Size()
+		case op.Lt:
+			if len(vm.Stack) < 2 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			c, ordered, ok := vm.compare(vm.Stack[len(vm.Stack)-2], vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(boolInt(ordered && c < 0)))
+			idx += opcode.Size()
+		case op.Le:
+			if len(vm.Stack) < 2 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			c, ordered, ok := vm.compare(vm.Stack[len(vm.Stack)-2], vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(boolInt(ordered && c <= 0)))
+			idx += opcode.Size()
+		case op.Gt:
+			if len(vm.Stack) < 2 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			c, ordered, ok := vm.compare(vm.Stack[len(vm.Stack)-2], vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(boolInt(ordered && c > 0)))
+			idx += opcode.Size()
+		case op.Ge:
+			if len(vm.Stack) < 2 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			c, ordered, ok := vm.compare(vm.Stack[len(vm.Stack)-2], vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(boolInt(ordered && c >= 0)))
+			idx += opcode.Size()
+		case op.Cmp:
+			if len(vm.Stack) < 2 {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			c, _, ok := vm.compare(vm.Stack[len(vm.Stack)-2], vm.Stack[len(vm.Stack)-1])
+			if !ok {
+				return idx, vmerr.InvalidTypeError{OpCode: opcode.String()}
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-2]
+			vm.Stack = append(vm.Stack, Int(int64(c)))
+			idx += opcode.Size()
+		case op.NativeCall:
+			index, err := op.ConstArgU32(code, idx+1)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			argc, err := op.ConstArgU32(code, idx+2)
+			if err != nil {
+				return idx, vmerr.MissingConstArgError{OpCode: opcode.String()}
+			}
+			if uint64(index) >= uint64(len(vm.Natives)) {
+				return idx, vmerr.IndexOutOfBoundsError{OpCode: opcode.String()}
+			}
+			first := len(vm.Stack) - int(argc)
+			if first < vm.FrameBase {
+				return idx, vmerr.TooFewValuesError{OpCode: opcode.String()}
+			}
+			// Native calls leave the hot path; boxing the arguments is fine
+			if err := vm.callNative(opcode.String(), vm.Natives[index], int(argc)); err != nil {
+				return idx, err
+			}
+			idx += opcode.Size()
 		default:
-			idx++
+			return idx, vmerr.InvalidOpcodeError{OpCode: uint32(opcode)}
 		}
 	}
-	return nil
+	return idx, nil
 }
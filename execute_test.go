@@ -0,0 +1,816 @@
+package gotvm_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm"
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+// The tables in this file are run on both VMs through vmtest.Compare, which
+// fails the test if they differ, and their expectations are checked against
+// the result of the reference VM.
+
+// b returns the opcodes and operands as bytecode.
+func b(values ...op.Op) op.ByteCode {
+	return values
+}
+
+// s returns the values as a stack, as vmtest.Result holds it.
+func s(values ...interface{}) []interface{} {
+	return append([]interface{}{}, values...)
+}
+
+// neg encodes a negative 32 bit operand.
+func neg(v int32) op.Op {
+	return op.Op(uint32(v))
+}
+
+// f32 encodes a 32 bit float operand.
+func f32(v float32) op.Op {
+	return op.Op(math.Float32bits(v))
+}
+
+// f64 encodes a 64 bit float operand, high word first.
+func f64(v float64) (op.Op, op.Op) {
+	bits := math.Float64bits(v)
+	return op.Op(bits >> 32), op.Op(bits)
+}
+
+// runners run a program on each of the VMs, for the tests which check details
+// vmtest.Diff does not compare, such as the contents of an error.
+var runners = map[string]func(op.ByteCode, ...vmopt.Option) vmtest.Result{
+	"fast":      vmtest.RunFast,
+	"reference": vmtest.RunReference,
+}
+
+func TestCallReturn(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		ExpectedStack []interface{}
+		ExpectedError error
+	}{
+		{
+			"call-and-return",
+			b(
+				op.PushInt32, 7, // 0
+				op.PushInt32, 2, // 2
+				op.PushInt32, 3, // 4
+				op.Call, 4, 2, // 6: call 10
+				op.Halt,    // 9
+				op.Copy, 0, // 10
+				op.Copy, 1, // 12
+				op.AddInt,    // 14
+				op.Return, 1, // 15
+			),
+			s(int64(7), int64(5)),
+			nil,
+		},
+		{
+			"return-discards-locals",
+			b(
+				op.PushInt32, 1, // 0
+				op.Call, 4, 1, // 2: call 6
+				op.Halt,         // 5
+				op.PushInt32, 2, // 6
+				op.PushInt32, 3, // 8
+				op.Return, 1, // 10
+			),
+			s(int64(3)),
+			nil,
+		},
+		{
+			"nested-calls",
+			b(
+				op.PushInt32, 1, // 0
+				op.Call, 4, 1, // 2: call 6
+				op.Halt,    // 5
+				op.Copy, 0, // 6
+				op.Call, 5, 1, // 8: call 13
+				op.Return, 2, // 11
+				op.PushInt32, 1, // 13
+				op.AddInt,    // 15
+				op.Return, 1, // 16
+			),
+			s(int64(1), int64(2)),
+			nil,
+		},
+		{
+			"frame-relative-copy",
+			b(
+				op.PushInt32, 10, // 0
+				op.PushInt32, 20, // 2
+				op.Call, 5, 1, // 4: call 9
+				op.Halt,    // 7
+				op.Noop,    // 8
+				op.Copy, 0, // 9
+				op.Return, 1, // 11
+			),
+			s(int64(10), int64(20)),
+			nil,
+		},
+		{
+			"top-level-return-halts",
+			b(
+				op.PushInt32, 1,
+				op.PushInt32, 2,
+				op.Return, 1,
+				op.PushInt32, 3,
+			),
+			s(int64(2)),
+			nil,
+		},
+		{
+			"call-too-few-args",
+			b(op.PushInt32, 1, op.Call, 3, 2),
+			s(int64(1)),
+			vmerr.ErrTooFewValues,
+		},
+		{
+			"call-missing-arg",
+			b(op.Call, 3),
+			s(),
+			vmerr.ErrMissingConstArg,
+		},
+		{
+			"return-too-few-values",
+			b(op.PushInt32, 1, op.Call, 3, 0, op.Return, 1),
+			s(int64(1)),
+			vmerr.ErrTooFewValues,
+		},
+		{
+			"pop-past-frame-base",
+			b(op.PushInt32, 1, op.Call, 3, 0, op.Pop),
+			s(int64(1)),
+			vmerr.ErrTooFewValues,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, test.ExpectedError)
+			} else {
+				assert.NoError(t, result.Err)
+			}
+			assert.Equal(t, test.ExpectedStack, result.Stack)
+		})
+	}
+}
+
+func TestJump(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		ExpectedStack []interface{}
+		ExpectedError error
+	}{
+		{"jump-forward", b(op.Jump, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"jump-out-of-code", b(op.Jump, 100, op.PushInt32, 1), s(), nil},
+		{
+			"loop",
+			b(
+				op.PushInt32, 0, // 0: acc
+				op.PushInt32, 5, // 2: n
+				op.Copy, 1, // 4
+				op.JumpIfZero, 15, // 6: jump 21
+				op.Copy, 0, // 8
+				op.Copy, 1, // 10
+				op.AddInt,  // 12
+				op.Swap, 0, // 13
+				op.Pop,                // 15
+				op.PushInt32, neg(-1), // 16
+				op.AddInt,         // 18
+				op.Jump, neg(-15), // 19: jump 4
+				op.Pop, // 21
+			),
+			s(int64(15)),
+			nil,
+		},
+		{"if-zero-taken", b(op.PushUint32, 0, op.JumpIfZero, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"if-zero-not-taken", b(op.PushInt32, 2, op.JumpIfZero, 3, op.Halt, op.PushInt32, 1), s(), nil},
+		{"if-zero-float", b(op.PushFloat32, f32(0.5), op.JumpIfZero, 3, op.Halt, op.PushInt32, 1), s(), nil},
+		{"if-not-zero-taken", b(op.PushInt32, 2, op.JumpIfNotZero, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"if-not-zero-not-taken", b(op.PushInt32, 0, op.JumpIfNotZero, 3, op.Halt, op.PushInt32, 1), s(), nil},
+		{"if-zero-int-truncates", b(op.PushFloat32, f32(0.5), op.JumpIfZeroInt, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"if-zero-uint", b(op.PushInt32, 0, op.JumpIfZeroUint, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"if-zero-float-typed", b(op.PushInt32, 0, op.JumpIfZeroFloat, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"if-not-zero-int", b(op.PushInt32, 3, op.JumpIfNotZeroInt, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"if-not-zero-uint", b(op.PushInt32, 0, op.JumpIfNotZeroUint, 3, op.Halt, op.PushInt32, 1), s(), nil},
+		{"if-not-zero-float", b(op.PushFloat32, f32(0.5), op.JumpIfNotZeroFloat, 3, op.Halt, op.PushInt32, 1), s(int64(1)), nil},
+		{"jump-missing-arg", b(op.Jump), s(), vmerr.ErrMissingConstArg},
+		{"if-zero-missing-arg", b(op.PushInt32, 0, op.JumpIfZero), s(int64(0)), vmerr.ErrMissingConstArg},
+		{"if-zero-empty-stack", b(op.JumpIfZero, 3), s(), vmerr.ErrTooFewValues},
+		{"if-not-zero-int-empty-stack", b(op.JumpIfNotZeroInt, 3), s(), vmerr.ErrTooFewValues},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, test.ExpectedError)
+			} else {
+				assert.NoError(t, result.Err)
+			}
+			assert.Equal(t, test.ExpectedStack, result.Stack)
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	nanHi, nanLo := f64(math.NaN())
+	halfHi, halfLo := f64(1.5)
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		Expected      int64
+		ExpectedError error
+	}{
+		{"eq-true", b(op.PushInt32, 2, op.PushInt32, 2, op.Eq), 1, nil},
+		{"eq-false", b(op.PushInt32, 2, op.PushInt32, 3, op.Eq), 0, nil},
+		{"eq-mixed", b(op.PushInt32, 2, op.PushUint32, 2, op.Eq), 1, nil},
+		{"eq-mixed-negative", b(op.PushInt32, neg(-1), op.PushUint64, 0xFFFFFFFF, 0xFFFFFFFF, op.Eq), 0, nil},
+		{"ne-true", b(op.PushInt32, 2, op.PushInt32, 3, op.Ne), 1, nil},
+		{"ne-false", b(op.PushInt32, 2, op.PushInt32, 2, op.Ne), 0, nil},
+		{"lt-true", b(op.PushInt32, 2, op.PushInt32, 3, op.Lt), 1, nil},
+		{"lt-false", b(op.PushInt32, 3, op.PushInt32, 2, op.Lt), 0, nil},
+		{"lt-negative", b(op.PushInt32, neg(-3), op.PushInt32, 2, op.Lt), 1, nil},
+		{"lt-mixed-negative", b(op.PushInt32, neg(-1), op.PushUint64, 0xFFFFFFFF, 0xFFFFFFFF, op.Lt), 1, nil},
+		{"lt-mixed-large", b(op.PushUint64, 0xFFFFFFFF, 0xFFFFFFFF, op.PushInt32, 1, op.Lt), 0, nil},
+		{"le-equal", b(op.PushInt32, 2, op.PushInt32, 2, op.Le), 1, nil},
+		{"le-greater", b(op.PushInt32, 3, op.PushInt32, 2, op.Le), 0, nil},
+		{"gt-true", b(op.PushInt32, 3, op.PushInt32, 2, op.Gt), 1, nil},
+		{"gt-mixed-negative", b(op.PushUint32, 0, op.PushInt32, neg(-1), op.Gt), 1, nil},
+		{"ge-equal", b(op.PushUint32, 2, op.PushUint32, 2, op.Ge), 1, nil},
+		{"ge-less", b(op.PushUint32, 1, op.PushUint32, 2, op.Ge), 0, nil},
+		{"cmp-less", b(op.PushInt32, 1, op.PushInt32, 2, op.Cmp), -1, nil},
+		{"cmp-equal", b(op.PushInt32, 2, op.PushUint32, 2, op.Cmp), 0, nil},
+		{"cmp-greater", b(op.PushUint32, 3, op.PushInt32, neg(-2), op.Cmp), 1, nil},
+		{"float-lt", b(op.PushInt32, 1, op.PushFloat64, halfHi, halfLo, op.Lt), 1, nil},
+		{"float-eq", b(op.PushFloat64, halfHi, halfLo, op.PushFloat64, halfHi, halfLo, op.Eq), 1, nil},
+		{"float-truncation", b(op.PushFloat64, halfHi, halfLo, op.PushInt32, 1, op.Gt), 1, nil},
+		{"nan-eq", b(op.PushFloat64, nanHi, nanLo, op.PushFloat64, nanHi, nanLo, op.Eq), 0, nil},
+		{"nan-ne", b(op.PushFloat64, nanHi, nanLo, op.PushFloat64, nanHi, nanLo, op.Ne), 1, nil},
+		{"nan-lt", b(op.PushFloat64, nanHi, nanLo, op.PushInt32, 1, op.Lt), 0, nil},
+		{"nan-ge", b(op.PushFloat64, nanHi, nanLo, op.PushInt32, 1, op.Ge), 0, nil},
+		{"nan-cmp-less", b(op.PushFloat64, nanHi, nanLo, op.PushInt32, 1, op.Cmp), -1, nil},
+		{"nan-cmp-greater", b(op.PushInt32, 1, op.PushFloat64, nanHi, nanLo, op.Cmp), 1, nil},
+		{"nan-cmp-equal", b(op.PushFloat64, nanHi, nanLo, op.PushFloat64, nanHi, nanLo, op.Cmp), 0, nil},
+		{"too-few-values", b(op.PushInt32, 1, op.Lt), 0, vmerr.ErrTooFewValues},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, test.ExpectedError)
+				return
+			}
+			assert.NoError(t, result.Err)
+			assert.Equal(t, s(test.Expected), result.Stack)
+		})
+	}
+}
+
+func TestDivision(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		ExpectedStack []interface{}
+		ExpectedError error
+	}{
+		{"div-int", b(op.PushInt32, 3, op.PushInt32, 12, op.DivInt), s(int64(4)), nil},
+		{"div-int-zero", b(op.PushInt32, 0, op.PushInt32, 12, op.DivInt), s(), vmerr.ErrDivisionByZero},
+		{"div-int-overflow", b(op.PushInt32, neg(-1), op.PushInt64, 0x80000000, 0, op.DivInt), s(), vmerr.ErrIntegerOverflow},
+		{"div-int-too-few-values", b(op.PushInt32, 0, op.DivInt), s(), vmerr.ErrTooFewValues},
+		{"div-int-float-zero", b(op.PushFloat32, 0x3F000000, op.PushInt32, 1, op.DivInt), s(), vmerr.ErrDivisionByZero},
+		{"div-const-i32", b(op.PushInt32, 12, op.DivConstInt32, neg(-3)), s(int64(-4)), nil},
+		{"div-const-i32-zero", b(op.PushInt32, 12, op.DivConstInt32, 0), s(), vmerr.ErrDivisionByZero},
+		{"div-const-i32-overflow", b(op.PushInt64, 0x80000000, 0, op.DivConstInt32, neg(-1)), s(), vmerr.ErrIntegerOverflow},
+		{"div-const-i64", b(op.PushInt32, 12, op.DivConstInt64, 0, 3), s(int64(4)), nil},
+		{"div-const-i64-zero", b(op.PushInt32, 12, op.DivConstInt64, 0, 0), s(), vmerr.ErrDivisionByZero},
+		{"div-const-i64-overflow", b(op.PushInt64, 0x80000000, 0, op.DivConstInt64, 0xFFFFFFFF, 0xFFFFFFFF), s(), vmerr.ErrIntegerOverflow},
+		{"div-const-u32", b(op.PushUint32, 12, op.DivConstUint32, 3), s(uint64(4)), nil},
+		{"div-const-u32-zero", b(op.PushUint32, 12, op.DivConstUint32, 0), s(), vmerr.ErrDivisionByZero},
+		{"div-const-u64", b(op.PushUint32, 12, op.DivConstUint64, 0, 3), s(uint64(4)), nil},
+		{"div-const-u64-zero", b(op.PushUint32, 12, op.DivConstUint64, 0, 0), s(), vmerr.ErrDivisionByZero},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, test.ExpectedError)
+			} else {
+				assert.NoError(t, result.Err)
+			}
+			assert.Equal(t, test.ExpectedStack, result.Stack)
+		})
+	}
+}
+
+func TestGas(t *testing.T) {
+	t.Parallel()
+
+	add := b(op.PushInt32, 1, op.PushInt32, 2, op.AddInt, op.Halt)
+
+	tests := []struct {
+		Name            string
+		Code            op.ByteCode
+		GasLimit        uint64
+		ExpectedGasUsed uint64
+		ExpectedError   error
+	}{
+		{"unlimited", add, 0, 4, nil},
+		{"exact-limit", add, 4, 4, nil},
+		{"out-of-gas", add, 3, 3, vmerr.OutOfGasError{OpCode: "Halt", Offset: 5}},
+		{"infinite-loop", b(op.Noop, op.Jump, 0xFFFFFFFF), 100, 100, vmerr.OutOfGasError{OpCode: "Noop", Offset: 0}},
+		{"call-cost", b(op.Call, 4, 0, op.Halt, op.Return, 0), 0, 7, nil},
+		{"div-cost", b(op.PushInt32, 2, op.DivConstInt32, 2), 0, 3, nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code, vmopt.WithGasLimit(test.GasLimit))
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, vmerr.ErrOutOfGas)
+				assert.Equal(t, test.ExpectedError, errors.Unwrap(result.Err))
+			} else {
+				assert.NoError(t, result.Err)
+			}
+			assert.Equal(t, test.ExpectedGasUsed, result.GasUsed)
+		})
+	}
+}
+
+func TestExecuteContext(t *testing.T) {
+	t.Parallel()
+
+	loop := b(op.Noop, op.Jump, 0xFFFFFFFF)
+
+	t.Run("completes", func(t *testing.T) {
+		t.Parallel()
+
+		vm := gotvm.New()
+		err := vm.ExecuteContext(context.Background(), b(op.PushInt32, 1, op.Halt))
+		assert.NoError(t, err)
+		assert.Len(t, vm.Stack, 1)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		vm := gotvm.New()
+		err := vm.ExecuteContext(ctx, b(op.PushInt32, 1, op.Halt))
+		assert.Equal(t, vmerr.InterruptedError{OpCode: "PushI32", Offset: 0, Err: context.Canceled}, errors.Unwrap(err))
+		assert.Empty(t, vm.Stack)
+	})
+	t.Run("deadline", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		vm := gotvm.New()
+		err := vm.ExecuteContext(ctx, loop)
+		assert.ErrorIs(t, err, vmerr.ErrInterrupted)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		MaxStack      int
+		MaxFrames     int
+		ExpectedDepth int
+		ExpectedError error
+	}{
+		{"stack-within-limit", b(op.PushInt32, 1, op.PushInt32, 2), 2, 0, 2, nil},
+		{"stack-overflow", b(op.PushInt32, 1, op.PushInt32, 2, op.PushInt32, 3), 2, 0, 2, vmerr.StackOverflowError{OpCode: "PushI32"}},
+		{"copy-overflow", b(op.PushInt32, 1, op.Copy, 0), 1, 0, 1, vmerr.StackOverflowError{OpCode: "Copy"}},
+		{"stack-unlimited", b(op.PushInt32, 1, op.PushInt32, 2, op.PushInt32, 3), 0, 0, 3, nil},
+		{"calls-within-limit", b(op.Call, 4, 0, op.Halt, op.Return, 0), 0, 1, 0, nil},
+		{"infinite-recursion", b(op.Call, 0, 0), 0, 3, 0, vmerr.CallDepthExceededError{OpCode: "Call"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code, vmopt.WithMaxStack(test.MaxStack), vmopt.WithMaxFrames(test.MaxFrames))
+			if test.ExpectedError != nil {
+				assert.Equal(t, test.ExpectedError, errors.Unwrap(result.Err))
+			} else {
+				assert.NoError(t, result.Err)
+			}
+			assert.Len(t, result.Stack, test.ExpectedDepth)
+		})
+	}
+}
+
+func TestNativeCall(t *testing.T) {
+	t.Parallel()
+
+	errNative := errors.New("native failed")
+	natives := vmopt.WithNatives(
+		vmopt.Native{Name: "sum", Func: func(args []interface{}) ([]interface{}, error) {
+			total := int64(0)
+			for _, arg := range args {
+				total += arg.(int64)
+			}
+			return []interface{}{total, int64(len(args))}, nil
+		}},
+		vmopt.Native{Name: "fail", Func: func(args []interface{}) ([]interface{}, error) {
+			return nil, errNative
+		}},
+		vmopt.Native{Name: "string", Func: func(args []interface{}) ([]interface{}, error) {
+			return []interface{}{"str"}, nil
+		}},
+	)
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		ExpectedStack []interface{}
+		ExpectedError error
+	}{
+		{"sum", b(op.PushInt32, 7, op.PushInt32, 2, op.PushInt32, 3, op.NativeCall, 0, 2), s(int64(7), int64(5), int64(2)), nil},
+		{"no-args", b(op.NativeCall, 0, 0), s(int64(0), int64(0)), nil},
+		{"too-few-values", b(op.PushInt32, 1, op.NativeCall, 0, 2), s(int64(1)), vmerr.ErrTooFewValues},
+		{"error", b(op.NativeCall, 1, 0), s(), errNative},
+		{"error-sentinel", b(op.NativeCall, 1, 0), s(), vmerr.ErrNative},
+		{"invalid-result", b(op.NativeCall, 2, 0), s(), vmerr.ErrInvalidType},
+		{"unregistered", b(op.NativeCall, 3, 0), s(), vmerr.ErrIndexOutOfBounds},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code, natives)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, test.ExpectedError)
+			} else {
+				assert.NoError(t, result.Err)
+			}
+			assert.Equal(t, test.ExpectedStack, result.Stack)
+		})
+	}
+}
+
+func TestStrict(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		ExpectedError error
+	}{
+		{"add-int", b(op.PushInt32, 1, op.PushInt32, 2, op.AddInt), nil},
+		{"add-int-uint", b(op.PushInt32, 1, op.PushUint32, 2, op.AddInt), vmerr.ErrInvalidType},
+		{"add-const-float", b(op.PushFloat32, 0x3F800000, op.AddConstInt32, 1), vmerr.ErrInvalidType},
+		{"add-const-uint", b(op.PushUint32, 1, op.AddConstUint32, 1), nil},
+		{"jump-typed", b(op.PushInt32, 0, op.JumpIfZeroFloat, 2), vmerr.ErrInvalidType},
+		{"jump-generic", b(op.PushFloat32, 0, op.JumpIfZero, 2), nil},
+		{"compare-same", b(op.PushUint32, 1, op.PushUint32, 2, op.Lt), nil},
+		{"compare-mixed", b(op.PushInt32, 1, op.PushUint32, 2, op.Lt), vmerr.ErrInvalidType},
+		{"negative", b(op.PushFloat32, 0x3F800000, op.Negative), nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code, vmopt.WithStrict(true))
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, test.ExpectedError)
+			} else {
+				assert.NoError(t, result.Err)
+			}
+			assert.NoError(t, vmtest.Compare(t, test.Code).Err, "lenient mode")
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	t.Parallel()
+
+	vm := gotvm.New(
+		vmopt.WithStackSize(16),
+		vmopt.WithMaxStack(32),
+		vmopt.WithMaxFrames(4),
+		vmopt.WithGasLimit(100),
+		vmopt.WithStrict(true),
+	)
+	assert.Equal(t, 16, cap(vm.Stack))
+	assert.Equal(t, 32, vm.MaxStack)
+	assert.Equal(t, 4, vm.MaxFrames)
+	assert.Equal(t, uint64(100), vm.GasLimit)
+	assert.True(t, vm.Strict)
+
+	vm = gotvm.New()
+	assert.Equal(t, vmopt.DefaultStackSize, cap(vm.Stack))
+	assert.Zero(t, vm.MaxStack)
+	assert.Zero(t, vm.MaxFrames)
+	assert.Zero(t, vm.GasLimit)
+	assert.False(t, vm.Strict)
+}
+
+func TestRuntimeError(t *testing.T) {
+	t.Parallel()
+
+	for name, run := range runners {
+		run := run
+		t.Run(name+"/location", func(t *testing.T) {
+			t.Parallel()
+
+			debug := op.NewDebugInfo()
+			debug.Lines[0] = 3
+			debug.Lines[2] = 4
+			err := run(b(op.PushInt32, 1, op.DivConstInt32, 0), vmopt.WithDebugInfo(debug)).Err
+
+			var runtimeErr vmerr.RuntimeError
+			if assert.ErrorAs(t, err, &runtimeErr) {
+				assert.Equal(t, 2, runtimeErr.Offset)
+				assert.Equal(t, "DivConstI32", runtimeErr.OpCode)
+				assert.Equal(t, 4, runtimeErr.Line)
+				assert.Equal(t, []interface{}{int64(1)}, runtimeErr.Stack)
+			}
+			assert.ErrorIs(t, err, vmerr.ErrDivisionByZero)
+		})
+		t.Run(name+"/stack-snapshot", func(t *testing.T) {
+			t.Parallel()
+
+			code := op.ByteCode{}
+			for i := 0; i < vmerr.StackSnapshotSize+2; i++ {
+				code = append(code, op.PushInt32, op.Op(i))
+			}
+			code = append(code, op.Op(0xDEAD))
+			err := run(code).Err
+
+			var runtimeErr vmerr.RuntimeError
+			if assert.ErrorAs(t, err, &runtimeErr) {
+				assert.Equal(t, len(code)-1, runtimeErr.Offset)
+				assert.Equal(t, "Op(0xdead)", runtimeErr.OpCode)
+				assert.Zero(t, runtimeErr.Line)
+				assert.Len(t, runtimeErr.Stack, vmerr.StackSnapshotSize)
+				assert.Equal(t, int64(vmerr.StackSnapshotSize+1), runtimeErr.Stack[vmerr.StackSnapshotSize-1])
+			}
+			assert.ErrorIs(t, err, vmerr.ErrInvalidOpcode)
+		})
+	}
+
+	// The snapshot is the stack as it was when the failing instruction
+	// started, including the values it popped before it failed
+	snapshots := []struct {
+		Name     string
+		Code     op.ByteCode
+		Expected []interface{}
+	}{
+		{"div-zero", b(op.PushInt32, 0, op.PushInt32, 1, op.DivInt), s(int64(0), int64(1))},
+		{"div-const-overflow", b(op.PushInt64, 0x80000000, 0, op.DivConstInt64, 0xFFFFFFFF, 0xFFFFFFFF), s(int64(math.MinInt64))},
+		{"too-few-values", b(op.PushUint32, 2, op.AddInt), s(uint64(2))},
+		{"pop-n", b(op.PushInt32, 1, op.PushInt32, 2, op.PopN, 3), s(int64(1), int64(2))},
+		{"return", b(op.PushInt32, 1, op.Call, 3, 1, op.Return, 2), s(int64(1))},
+		{"compare-strict", b(op.PushInt32, 1, op.PushUint32, 2, op.Lt), s(int64(1), uint64(2))},
+	}
+	for _, test := range snapshots {
+		test := test
+		for name, run := range runners {
+			run := run
+			t.Run(name+"/snapshot/"+test.Name, func(t *testing.T) {
+				t.Parallel()
+
+				var runtimeErr vmerr.RuntimeError
+				if assert.ErrorAs(t, run(test.Code, vmopt.WithStrict(true)).Err, &runtimeErr) {
+					assert.Equal(t, test.Expected, runtimeErr.Stack)
+				}
+			})
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name           string
+		Code           op.ByteCode
+		ExpectedEvents []string
+	}{
+		{
+			"call",
+			b(op.PushInt32, 5, op.Call, 4, 1, op.Halt, op.Increment, op.Return, 1),
+			[]string{
+				"0: PushI32 []",
+				"2: Call [5]",
+				"2: call 6 depth 1",
+				"6: Increment [5]",
+				"7: Return [6]",
+				"7: return 5 depth 0",
+				"5: Halt [6]",
+				"halt [6]",
+			},
+		},
+		{"end-of-code", b(op.Noop), []string{"0: Noop []", "halt []"}},
+		{"error", b(op.PushInt32, 1, op.Pop, op.Pop), []string{"0: PushI32 []", "2: Pop [1]", "3: Pop []", "3: error"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		for name, run := range runners {
+			run := run
+			t.Run(test.Name+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				tracer := &vmtest.Recorder{}
+				run(test.Code, vmopt.WithTracer(tracer))
+				assert.Equal(t, test.ExpectedEvents, tracer.Events)
+			})
+		}
+	}
+}
+
+func TestNoTracerAllocations(t *testing.T) {
+	code := op.ByteCode{op.PushInt32, 1000, op.Decrement, op.Copy, 0, op.JumpIfNotZero, 0xFFFFFFFD}
+	vm := gotvm.New()
+	allocs := testing.AllocsPerRun(10, func() {
+		vm.Stack = vm.Stack[:0]
+		_ = vm.Execute(code)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestStackTrace(t *testing.T) {
+	t.Parallel()
+
+	source := []string{
+		"    PushI32 7",
+		"    PushI32 1",
+		"    Call outer 1",
+		"    Halt",
+		"outer:",
+		"    PushI32 2",
+		"    Call inner 1",
+		"    Return 1",
+		"inner:",
+		"    DivConstI32 0",
+	}
+	code, debug := assembler.AssembleDebug(source, nil)
+
+	for name, run := range runners {
+		run := run
+		t.Run(name+"/symbols", func(t *testing.T) {
+			t.Parallel()
+
+			err := run(code, vmopt.WithDebugInfo(debug)).Err
+			assert.ErrorIs(t, err, vmerr.ErrDivisionByZero)
+			assert.Equal(t, []vmerr.Frame{
+				{Function: "inner", Entry: 15, CallSite: 10, FrameBase: 2},
+				{Function: "outer", Entry: 8, CallSite: 4, FrameBase: 1},
+				{Function: "", Entry: 0, CallSite: -1, FrameBase: 0},
+			}, vmerr.StackTrace(err))
+		})
+		t.Run(name+"/no-symbols", func(t *testing.T) {
+			t.Parallel()
+
+			err := run(code).Err
+			assert.Equal(t, []vmerr.Frame{
+				{Entry: 15, CallSite: 10, FrameBase: 2},
+				{Entry: 8, CallSite: 4, FrameBase: 1},
+				{Entry: 0, CallSite: -1, FrameBase: 0},
+			}, vmerr.StackTrace(err))
+		})
+		t.Run(name+"/top-level", func(t *testing.T) {
+			t.Parallel()
+
+			err := run(op.ByteCode{op.Pop}).Err
+			assert.Equal(t, []vmerr.Frame{{CallSite: -1}}, vmerr.StackTrace(err))
+		})
+	}
+}
+
+func TestOpcodes(t *testing.T) {
+	t.Parallel()
+
+	halfHi, halfLo := f64(0.5)
+
+	tests := []struct {
+		Name          string
+		Code          op.ByteCode
+		ExpectedStack []interface{}
+		ExpectedError error
+	}{
+		{"push-f32", b(op.PushFloat32, f32(1.5)), s(1.5), nil},
+		{"push-f64", b(op.PushFloat64, halfHi, halfLo), s(0.5), nil},
+		{"push-f64-missing-arg", b(op.PushFloat64, halfHi), s(), vmerr.ErrMissingConstArg},
+		{"pop", b(op.PushInt32, 1, op.PushInt32, 2, op.Pop), s(int64(1)), nil},
+		{"pop-last", b(op.PushInt32, 1, op.Pop), s(), nil},
+		{"pop-empty", b(op.Pop), s(), vmerr.ErrTooFewValues},
+		{"pop-n", b(op.PushInt32, 1, op.PushInt32, 2, op.PushInt32, 3, op.PopN, 2), s(int64(1)), nil},
+		{"pop-n-too-many", b(op.PushInt32, 1, op.PopN, 2), s(int64(1)), vmerr.ErrTooFewValues},
+		{"copy", b(op.PushInt32, 1, op.PushInt32, 2, op.Copy, 0), s(int64(1), int64(2), int64(1)), nil},
+		{"copy-out-of-bounds", b(op.PushInt32, 1, op.Copy, 1), s(int64(1)), vmerr.ErrIndexOutOfBounds},
+		{"swap", b(op.PushInt32, 1, op.PushInt32, 2, op.Swap, 0), s(int64(2), int64(1)), nil},
+		{"swap-single", b(op.PushInt32, 1, op.Swap, 0), s(int64(1)), nil},
+		{"swap-out-of-bounds", b(op.PushInt32, 1, op.Swap, 1), s(int64(1)), vmerr.ErrIndexOutOfBounds},
+		{"negative-int", b(op.PushInt32, 3, op.Negative), s(int64(-3)), nil},
+		{"negative-uint", b(op.PushUint32, 1, op.Negative), s(uint64(math.MaxUint64)), nil},
+		{"negative-float", b(op.PushFloat32, f32(1.5), op.Negative), s(-1.5), nil},
+		{"add-int", b(op.PushInt32, 3, op.PushFloat32, f32(1.5), op.AddInt), s(int64(4)), nil},
+		{"sub-int", b(op.PushInt32, 3, op.PushInt32, 10, op.SubInt), s(int64(7)), nil},
+		{"mul-int", b(op.PushInt32, 3, op.PushUint32, 10, op.MulInt), s(int64(30)), nil},
+		{"add-const-i32", b(op.PushInt32, 3, op.AddConstInt32, neg(-1)), s(int64(2)), nil},
+		{"add-const-i64", b(op.PushInt32, 3, op.AddConstInt64, 1, 0), s(int64(0x100000003)), nil},
+		{"add-const-u32", b(op.PushInt32, 3, op.AddConstUint32, 2), s(uint64(5)), nil},
+		{"add-const-u64", b(op.PushUint32, 3, op.AddConstUint64, 1, 0), s(uint64(0x100000003)), nil},
+		{"add-const-f32", b(op.PushInt32, 3, op.AddConstFloat32, f32(0.5)), s(3.5), nil},
+		{"add-const-f64", b(op.PushInt32, 3, op.AddConstFloat64, halfHi, halfLo), s(3.5), nil},
+		{"add-const-missing-arg", b(op.PushInt32, 3, op.AddConstInt64, 1), s(int64(3)), vmerr.ErrMissingConstArg},
+		{"add-const-empty", b(op.AddConstInt32, 1), s(), vmerr.ErrTooFewValues},
+		{"sub-const-i32", b(op.PushInt32, 3, op.SubConstInt32, 5), s(int64(-2)), nil},
+		{"sub-const-i64", b(op.PushInt32, 3, op.SubConstInt64, 0, 5), s(int64(-2)), nil},
+		{"sub-const-u32", b(op.PushUint32, 3, op.SubConstUint32, 5), s(uint64(math.MaxUint64 - 1)), nil},
+		{"sub-const-u64", b(op.PushUint32, 7, op.SubConstUint64, 0, 5), s(uint64(2)), nil},
+		{"sub-const-f32", b(op.PushInt32, 3, op.SubConstFloat32, f32(0.5)), s(2.5), nil},
+		{"sub-const-f64", b(op.PushInt32, 3, op.SubConstFloat64, halfHi, halfLo), s(2.5), nil},
+		{"mul-const-i32", b(op.PushInt32, 3, op.MulConstInt32, neg(-2)), s(int64(-6)), nil},
+		{"mul-const-i64", b(op.PushInt32, 3, op.MulConstInt64, 0, 2), s(int64(6)), nil},
+		{"mul-const-u32", b(op.PushInt32, 3, op.MulConstUint32, 2), s(uint64(6)), nil},
+		{"mul-const-u64", b(op.PushInt32, 3, op.MulConstUint64, 0, 2), s(uint64(6)), nil},
+		{"mul-const-f32", b(op.PushInt32, 3, op.MulConstFloat32, f32(0.5)), s(1.5), nil},
+		{"mul-const-f64", b(op.PushInt32, 3, op.MulConstFloat64, halfHi, halfLo), s(1.5), nil},
+		{"div-const-f32", b(op.PushInt32, 3, op.DivConstFloat32, f32(0.5)), s(6.0), nil},
+		{"div-const-f64", b(op.PushInt32, 3, op.DivConstFloat64, halfHi, halfLo), s(6.0), nil},
+		{"div-const-f64-zero", b(op.PushInt32, 3, op.DivConstFloat64, 0, 0), s(math.Inf(1)), nil},
+		{"increment-int", b(op.PushInt32, 3, op.Increment), s(int64(4)), nil},
+		{"increment-uint", b(op.PushUint32, 3, op.Increment), s(uint64(4)), nil},
+		{"increment-float", b(op.PushFloat32, f32(0.5), op.Increment), s(1.5), nil},
+		{"increment-empty", b(op.Increment), s(), vmerr.ErrTooFewValues},
+		{"decrement-int", b(op.PushInt32, 3, op.Decrement), s(int64(2)), nil},
+		{"decrement-uint", b(op.PushUint32, 0, op.Decrement), s(uint64(math.MaxUint64)), nil},
+		{"decrement-float", b(op.PushFloat32, f32(0.5), op.Decrement), s(-0.5), nil},
+		{"invalid-opcode", b(op.PushInt32, 1, 0xDEAD, op.PushInt32, 2), s(int64(1)), vmerr.ErrInvalidOpcode},
+		{"native-call-unregistered", b(op.NativeCall, 0, 0), s(), vmerr.ErrIndexOutOfBounds},
+		{"halt", b(op.PushInt32, 1, op.Halt, op.PushInt32, 2), s(int64(1)), nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result := vmtest.Compare(t, test.Code)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, result.Err, test.ExpectedError)
+				return
+			}
+			assert.NoError(t, result.Err)
+			assert.Equal(t, test.ExpectedStack, result.Stack)
+		})
+	}
+}
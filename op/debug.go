@@ -0,0 +1,31 @@
+package op
+
+// DebugInfo maps the instructions of a ByteCode back to the source they were
+// assembled from.
+type DebugInfo struct {
+	Lines   map[int]int    // The source line of the instruction at each offset
+	Symbols map[int]string // The name of the label at each offset
+}
+
+// NewDebugInfo returns an empty DebugInfo.
+func NewDebugInfo() *DebugInfo {
+	return &DebugInfo{Lines: map[int]int{}, Symbols: map[int]string{}}
+}
+
+// Line returns the source line of the instruction at the given offset, or 0
+// if it is not known. A nil DebugInfo knows no lines.
+func (d *DebugInfo) Line(offset int) int {
+	if d == nil {
+		return 0
+	}
+	return d.Lines[offset]
+}
+
+// Symbol returns the name of the label at the given offset, or an empty string
+// if there is none. A nil DebugInfo has no symbols.
+func (d *DebugInfo) Symbol(offset int) string {
+	if d == nil {
+		return ""
+	}
+	return d.Symbols[offset]
+}
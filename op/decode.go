@@ -0,0 +1,83 @@
+package op
+
+import "github.com/tvarney/gotvm/vmerr"
+
+// Instruction is an opcode decoded together with its operands.
+type Instruction struct {
+	Offset int      // The offset of the opcode in the ByteCode
+	Op     Op       // The opcode
+	Args   []uint64 // The raw value of each operand of the opcode, in order
+}
+
+// Decode decodes the instruction at the given offset.
+//
+// A vmerr.InvalidOpcodeError is returned if the opcode is not defined, and a
+// vmerr.MissingConstArgError if the code ends before all of the operands.
+func Decode(code ByteCode, offset int) (Instruction, error) {
+	if offset < 0 || offset >= len(code) {
+		return Instruction{}, vmerr.ErrIndexOutOfBounds
+	}
+	opcode := code[offset]
+	meta, ok := Info(opcode)
+	if !ok {
+		return Instruction{}, vmerr.InvalidOpcodeError{OpCode: uint32(opcode)}
+	}
+
+	inst := Instruction{Offset: offset, Op: opcode, Args: make([]uint64, len(meta.Operands))}
+	idx := offset + 1
+	for n, operand := range meta.Operands {
+		var err error
+		if operand.Width() == 2 {
+			inst.Args[n], err = ConstArgU64(code, idx)
+		} else {
+			var v uint32
+			v, err = ConstArgU32(code, idx)
+			inst.Args[n] = uint64(v)
+		}
+		if err != nil {
+			return Instruction{}, vmerr.MissingConstArgError{OpCode: opcode.String()}
+		}
+		idx += operand.Width()
+	}
+	return inst, nil
+}
+
+// Size returns the number of Op values the instruction is encoded in.
+func (i Instruction) Size() int {
+	return i.Op.Size()
+}
+
+// Next returns the offset of the instruction after this one.
+func (i Instruction) Next() int {
+	return i.Offset + i.Size()
+}
+
+// Target returns the offset a branching instruction may continue at. The
+// second return value is false if the opcode has no offset operand.
+func (i Instruction) Target() (int, bool) {
+	meta, _ := Info(i.Op)
+	for n, operand := range meta.Operands {
+		if operand == OperandOffset && n < len(i.Args) {
+			return i.Offset + int(int32(uint32(i.Args[n]))), true
+		}
+	}
+	return 0, false
+}
+
+// Append encodes the instruction at the end of the code.
+func (i Instruction) Append(code ByteCode) ByteCode {
+	code = append(code, i.Op)
+	meta, _ := Info(i.Op)
+	for n, operand := range meta.Operands {
+		var v uint64
+		if n < len(i.Args) {
+			v = i.Args[n]
+		}
+		if operand.Width() == 2 {
+			code = append(code, Op(uint32(v>>32)), Op(uint32(v)))
+		} else {
+			code = append(code, Op(uint32(v)))
+		}
+	}
+	return code
+}
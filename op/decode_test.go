@@ -0,0 +1,58 @@
+package op_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/vmerr"
+)
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	b := func(values ...op.Op) op.ByteCode {
+		return values
+	}
+
+	tests := []struct {
+		Name           string
+		Code           op.ByteCode
+		Offset         int
+		ExpectedArgs   []uint64
+		ExpectedTarget int
+		ExpectedBranch bool
+		ExpectedError  error
+	}{
+		{"no-operands", b(op.Noop, op.AddInt), 1, []uint64{}, 0, false, nil},
+		{"int32", b(op.PushInt32, 0xFFFFFFFF), 0, []uint64{0xFFFFFFFF}, 0, false, nil},
+		{"int64", b(op.PushInt64, 1, 2), 0, []uint64{1<<32 | 2}, 0, false, nil},
+		{"jump", b(op.Noop, op.Jump, 0xFFFFFFFF), 1, []uint64{0xFFFFFFFF}, 0, true, nil},
+		{"call", b(op.Call, 5, 2), 0, []uint64{5, 2}, 5, true, nil},
+		{"invalid-opcode", b(op.Op(0xDEAD)), 0, nil, 0, false, vmerr.ErrInvalidOpcode},
+		{"truncated", b(op.PushInt64, 1), 0, nil, 0, false, vmerr.ErrMissingConstArg},
+		{"out-of-range", b(op.Noop), 1, nil, 0, false, vmerr.ErrIndexOutOfBounds},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			inst, err := op.Decode(test.Code, test.Offset)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, err, test.ExpectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.Offset, inst.Offset)
+			assert.Equal(t, test.Code[test.Offset], inst.Op)
+			assert.Equal(t, test.ExpectedArgs, inst.Args)
+			assert.Equal(t, test.Offset+inst.Size(), inst.Next())
+			target, ok := inst.Target()
+			assert.Equal(t, test.ExpectedBranch, ok)
+			assert.Equal(t, test.ExpectedTarget, target)
+			assert.Equal(t, test.Code[test.Offset:inst.Next()], inst.Append(nil))
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package op
+
+import "strconv"
+
+// Operand is the kind of a constant operand encoded in the bytecode after an
+// opcode.
+type Operand int
+
+const (
+	OperandInt32   Operand = iota // A 32-bit signed int
+	OperandInt64                  // A 64-bit signed int
+	OperandUint32                 // A 32-bit unsigned int
+	OperandUint64                 // A 64-bit unsigned int
+	OperandFloat32                // A 32-bit float
+	OperandFloat64                // A 64-bit float
+	OperandOffset                 // A 32-bit signed offset relative to the opcode
+)
+
+// Width returns the number of Op values the operand is encoded in.
+func (o Operand) Width() int {
+	switch o {
+	case OperandInt64, OperandUint64, OperandFloat64:
+		return 2
+	}
+	return 1
+}
+
+// Flags describe the control flow behavior of an opcode.
+type Flags uint32
+
+const (
+	FlagBranch      Flags = 1 << iota // May continue at the target of an offset operand
+	FlagConditional                   // Only branches if a condition holds
+	FlagCall                          // Pushes a new call frame
+	FlagReturn                        // Pops a call frame; never continues to the next opcode
+	FlagHalt                          // Stops execution
+)
+
+// Variable is the value of Metadata.Pops and Metadata.Pushes for opcodes
+// where the number of values depends on the operands or on the program.
+const Variable = -1
+
+// Metadata describes the encoding and behavior of an opcode.
+type Metadata struct {
+	Mnemonic string    // The name of the opcode in assembly
+	Operands []Operand // The constant operands following the opcode
+	Pops     int       // The number of values taken from the stack
+	Pushes   int       // The number of values added to the stack
+	Flags    Flags
+	Cost     uint64 // The gas charged for executing the opcode
+}
+
+// Size returns the number of Op values an instruction is encoded in,
+// including the opcode itself.
+func (m Metadata) Size() int {
+	size := 1
+	for _, operand := range m.Operands {
+		size += operand.Width()
+	}
+	return size
+}
+
+// Is checks if all of the given flags are set for the opcode.
+func (m Metadata) Is(flags Flags) bool {
+	return m.Flags&flags == flags
+}
+
+func operands(values ...Operand) []Operand {
+	return values
+}
+
+var (
+	// table is the metadata of every opcode. The cost is a rough measure of
+	// the work an opcode does next to a push: a division also checks its
+	// divisor, a Call saves a frame, and a NativeCall converts its arguments
+	// and results and runs code the VM can't meter.
+	table = [numOps]Metadata{
+		Noop:        {"Noop", nil, 0, 0, 0, 1},
+		Halt:        {"Halt", nil, 0, 0, FlagHalt, 1},
+		PushInt32:   {"PushI32", operands(OperandInt32), 0, 1, 0, 1},
+		PushInt64:   {"PushI64", operands(OperandInt64), 0, 1, 0, 1},
+		PushUint32:  {"PushU32", operands(OperandUint32), 0, 1, 0, 1},
+		PushUint64:  {"PushU64", operands(OperandUint64), 0, 1, 0, 1},
+		PushFloat32: {"PushF32", operands(OperandFloat32), 0, 1, 0, 1},
+		PushFloat64: {"PushF64", operands(OperandFloat64), 0, 1, 0, 1},
+		Pop:         {"Pop", nil, 1, 0, 0, 1},
+		PopN:        {"PopN", operands(OperandUint32), Variable, 0, 0, 1},
+		Copy:        {"Copy", operands(OperandUint32), 0, 1, 0, 1},
+		Swap:        {"Swap", operands(OperandUint32), 1, 1, 0, 1},
+		Negative:    {"Negative", nil, 1, 1, 0, 1},
+		AddInt:      {"AddInt", nil, 2, 1, 0, 1},
+		SubInt:      {"SubInt", nil, 2, 1, 0, 1},
+		MulInt:      {"MulInt", nil, 2, 1, 0, 1},
+		DivInt:      {"DivInt", nil, 2, 1, 0, 2},
+
+		AddConstInt32:   {"AddConstI32", operands(OperandInt32), 1, 1, 0, 1},
+		AddConstInt64:   {"AddConstI64", operands(OperandInt64), 1, 1, 0, 1},
+		AddConstUint32:  {"AddConstU32", operands(OperandUint32), 1, 1, 0, 1},
+		AddConstUint64:  {"AddConstU64", operands(OperandUint64), 1, 1, 0, 1},
+		AddConstFloat32: {"AddConstF32", operands(OperandFloat32), 1, 1, 0, 1},
+		AddConstFloat64: {"AddConstF64", operands(OperandFloat64), 1, 1, 0, 1},
+		SubConstInt32:   {"SubConstI32", operands(OperandInt32), 1, 1, 0, 1},
+		SubConstInt64:   {"SubConstI64", operands(OperandInt64), 1, 1, 0, 1},
+		SubConstUint32:  {"SubConstU32", operands(OperandUint32), 1, 1, 0, 1},
+		SubConstUint64:  {"SubConstU64", operands(OperandUint64), 1, 1, 0, 1},
+		SubConstFloat32: {"SubConstF32", operands(OperandFloat32), 1, 1, 0, 1},
+		SubConstFloat64: {"SubConstF64", operands(OperandFloat64), 1, 1, 0, 1},
+		MulConstInt32:   {"MulConstI32", operands(OperandInt32), 1, 1, 0, 1},
+		MulConstInt64:   {"MulConstI64", operands(OperandInt64), 1, 1, 0, 1},
+		MulConstUint32:  {"MulConstU32", operands(OperandUint32), 1, 1, 0, 1},
+		MulConstUint64:  {"MulConstU64", operands(OperandUint64), 1, 1, 0, 1},
+		MulConstFloat32: {"MulConstF32", operands(OperandFloat32), 1, 1, 0, 1},
+		MulConstFloat64: {"MulConstF64", operands(OperandFloat64), 1, 1, 0, 1},
+		DivConstInt32:   {"DivConstI32", operands(OperandInt32), 1, 1, 0, 2},
+		DivConstInt64:   {"DivConstI64", operands(OperandInt64), 1, 1, 0, 2},
+		DivConstUint32:  {"DivConstU32", operands(OperandUint32), 1, 1, 0, 2},
+		DivConstUint64:  {"DivConstU64", operands(OperandUint64), 1, 1, 0, 2},
+		DivConstFloat32: {"DivConstF32", operands(OperandFloat32), 1, 1, 0, 2},
+		DivConstFloat64: {"DivConstF64", operands(OperandFloat64), 1, 1, 0, 2},
+
+		Increment: {"Increment", nil, 1, 1, 0, 1},
+		Decrement: {"Decrement", nil, 1, 1, 0, 1},
+
+		Call:       {"Call", operands(OperandOffset, OperandUint32), Variable, Variable, FlagBranch | FlagCall, 4},
+		NativeCall: {"NativeCall", operands(OperandUint32, OperandUint32), Variable, Variable, 0, 8},
+		Return:     {"Return", operands(OperandUint32), Variable, Variable, FlagReturn, 2},
+
+		Jump:               {"Jump", operands(OperandOffset), 0, 0, FlagBranch, 1},
+		JumpIfZero:         {"JumpIfZero", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+		JumpIfNotZero:      {"JumpIfNotZero", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+		JumpIfZeroInt:      {"JumpIfZeroInt", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+		JumpIfZeroUint:     {"JumpIfZeroUint", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+		JumpIfZeroFloat:    {"JumpIfZeroFloat", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+		JumpIfNotZeroInt:   {"JumpIfNotZeroInt", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+		JumpIfNotZeroUint:  {"JumpIfNotZeroUint", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+		JumpIfNotZeroFloat: {"JumpIfNotZeroFloat", operands(OperandOffset), 1, 0, FlagBranch | FlagConditional, 1},
+
+		Eq:  {"Eq", nil, 2, 1, 0, 1},
+		Ne:  {"Ne", nil, 2, 1, 0, 1},
+		Lt:  {"Lt", nil, 2, 1, 0, 1},
+		Le:  {"Le", nil, 2, 1, 0, 1},
+		Gt:  {"Gt", nil, 2, 1, 0, 1},
+		Ge:  {"Ge", nil, 2, 1, 0, 1},
+		Cmp: {"Cmp", nil, 2, 1, 0, 1},
+	}
+
+	// sizes caches the size of each instruction for the hot loop of the VM
+	sizes [numOps]int
+)
+
+// Info returns the Metadata for the given opcode. The second return value is
+// false if the opcode is not defined.
+func Info(o Op) (Metadata, bool) {
+	if !o.Valid() {
+		return Metadata{}, false
+	}
+	return table[o], true
+}
+
+// Valid checks if the opcode is defined.
+func (o Op) Valid() bool {
+	return o < numOps
+}
+
+// Size returns the number of Op values the instruction starting with this
+// opcode is encoded in. Undefined opcodes have a size of 1.
+func (o Op) Size() int {
+	if o < numOps {
+		return sizes[o]
+	}
+	return 1
+}
+
+// String returns the mnemonic of the opcode, or a hexadecimal representation
+// of the value if the opcode is not defined.
+func (o Op) String() string {
+	if o < numOps {
+		return table[o].Mnemonic
+	}
+	return "Op(0x" + strconv.FormatUint(uint64(o), 16) + ")"
+}
+
+// Cost returns the gas charged for executing the opcode. Undefined opcodes
+// are never executed and cost nothing.
+func (o Op) Cost() uint64 {
+	if o < numOps {
+		return table[o].Cost
+	}
+	return 0
+}
+
+func init() {
+	for idx, meta := range table {
+		sizes[idx] = meta.Size()
+	}
+}
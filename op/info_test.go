@@ -0,0 +1,64 @@
+package op_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm/op"
+)
+
+func TestInfo(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Complete", func(t *testing.T) {
+		t.Parallel()
+
+		mnemonics := map[string]op.Op{}
+		for opcode := op.Op(0); opcode.Valid(); opcode++ {
+			meta, ok := op.Info(opcode)
+			assert.True(t, ok)
+			assert.NotEmpty(t, meta.Mnemonic, "opcode %d has no mnemonic", uint32(opcode))
+			name := strings.ToLower(meta.Mnemonic)
+			if prev, found := mnemonics[name]; found {
+				t.Errorf("mnemonic %q used by both %d and %d", meta.Mnemonic, uint32(prev), uint32(opcode))
+			}
+			mnemonics[name] = opcode
+			assert.Equal(t, meta.Size(), opcode.Size())
+			assert.NotZero(t, meta.Cost, "opcode %s has no cost", meta.Mnemonic)
+			assert.Equal(t, meta.Cost, opcode.Cost())
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		_, ok := op.Info(op.Op(0xDEAD))
+		assert.False(t, ok)
+		assert.Equal(t, "Op(0xdead)", op.Op(0xDEAD).String())
+		assert.Equal(t, 1, op.Op(0xDEAD).Size())
+		assert.Equal(t, uint64(0), op.Op(0xDEAD).Cost())
+	})
+
+	tests := []struct {
+		Name             string
+		Op               op.Op
+		ExpectedMnemonic string
+		ExpectedSize     int
+	}{
+		{"noop", op.Noop, "Noop", 1},
+		{"push-i32", op.PushInt32, "PushI32", 2},
+		{"push-f64", op.PushFloat64, "PushF64", 3},
+		{"add-const-u64", op.AddConstUint64, "AddConstU64", 3},
+		{"call", op.Call, "Call", 3},
+		{"jump", op.Jump, "Jump", 2},
+		{"cmp", op.Cmp, "Cmp", 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.ExpectedMnemonic, test.Op.String())
+			assert.Equal(t, test.ExpectedSize, test.Op.Size())
+		})
+	}
+}
@@ -8,8 +8,13 @@ type Op uint32
 
 type ByteCode []Op
 
+// Version is the version of the instruction set. It is incremented whenever
+// an opcode or the encoding of its operands changes, so that bytecode saved by
+// an older version is not run with the wrong meaning.
+const Version = 1
+
 const (
-	Noop = iota
+	Noop Op = iota
 	Halt
 
 	// Stack Operations
@@ -67,8 +72,32 @@ const (
 	Decrement // Decrement the topmost value on the stack
 
 	// Functions
-	Call
-	NativeCall
+	Call       // Call the function at a relative offset with N arguments
+	NativeCall // Call a native function
+	Return     // Return N values from the current function
+
+	// Branches
+	Jump               // Jump by a relative offset
+	JumpIfZero         // Pop the topmost value and jump if it is zero
+	JumpIfNotZero      // Pop the topmost value and jump if it is not zero
+	JumpIfZeroInt      // Pop the topmost value as an int and jump if it is zero
+	JumpIfZeroUint     // Pop the topmost value as a uint and jump if it is zero
+	JumpIfZeroFloat    // Pop the topmost value as a float and jump if it is zero
+	JumpIfNotZeroInt   // Pop the topmost value as an int and jump if it is not zero
+	JumpIfNotZeroUint  // Pop the topmost value as a uint and jump if it is not zero
+	JumpIfNotZeroFloat // Pop the topmost value as a float and jump if it is not zero
+
+	// Comparisons
+	Eq  // Push 1 if the topmost two values are equal, otherwise 0
+	Ne  // Push 1 if the topmost two values are not equal, otherwise 0
+	Lt  // Push 1 if the second value is less than the topmost value, otherwise 0
+	Le  // Push 1 if the second value is less than or equal to the topmost value, otherwise 0
+	Gt  // Push 1 if the second value is greater than the topmost value, otherwise 0
+	Ge  // Push 1 if the second value is greater than or equal to the topmost value, otherwise 0
+	Cmp // Push -1, 0 or 1 as the second value is less than, equal to or greater than the topmost
+
+	// numOps is the number of defined opcodes; it must remain the last value
+	numOps
 )
 
 // ConstArgU32 converts the Op value at the given index to a uint32 value.
@@ -0,0 +1,126 @@
+package optimize
+
+import (
+	"math"
+
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/reference"
+	"github.com/tvarney/gotvm/typecheck"
+	"github.com/tvarney/gotvm/vmopt"
+)
+
+// Fold returns a copy of the code, and of its debug info if it is not nil,
+// with arithmetic on constants computed ahead of time. An instruction which
+// only computes a value from its operands, such as AddInt or Lt, is folded
+// when each operand is pushed by the instructions just before it; the
+// instructions are replaced by a single push of the result. Folding is
+// repeated until nothing changes, so a whole expression of constants becomes
+// a single push.
+//
+// The result is computed by running the instructions on the reference VM with
+// the given strictness, so it is exactly the value the program would compute
+// on a VM in that mode. Instructions are only folded if they run without an
+// error; an instruction which would fail, such as a division by zero, is left
+// to fail when the program runs. Folding in strict mode gives code which runs
+// the same in either mode. When strict is false, operands are coerced as
+// PopInt, PopUint and PopFloat coerce them, and the code may no longer fail in
+// strict mode where it did before. A float is not converted to an integer
+// unless it is in range of both integer types, as Go leaves the result of
+// other conversions to the platform.
+//
+// Like Optimize, the code must pass verify.Verify, and the error from
+// typecheck.Infer is returned if it is rejected.
+func Fold(code op.ByteCode, debug *op.DebugInfo, strict bool) (op.ByteCode, *op.DebugInfo, error) {
+	return run(code, debug, func(insts []op.Instruction, targets map[int]bool, _ *typecheck.Result) (rewrite, bool) {
+		return fold(insts, targets, strict)
+	})
+}
+
+// fold is the rule of Fold.
+func fold(insts []op.Instruction, targets map[int]bool, strict bool) (rewrite, bool) {
+	for n := 1; n < len(insts) && n <= 2; n++ {
+		inst := insts[n]
+		if meta, _ := op.Info(inst.Op); !pure(inst.Op) || meta.Pops != n {
+			continue
+		}
+		constant := true
+		for idx, operand := range insts[:n] {
+			constant = constant && operand.Op >= op.PushInt32 && operand.Op <= op.PushFloat64 && !targets[insts[idx+1].Offset]
+		}
+		if !constant {
+			continue
+		}
+		if result, ok := evaluate(insts[:n+1], strict); ok {
+			return rewrite{Count: n + 1, Replace: []op.Instruction{result}}, true
+		}
+	}
+	return rewrite{}, false
+}
+
+// pure checks if the opcode only computes a value from the values it pops.
+func pure(opcode op.Op) bool {
+	return (opcode >= op.Negative && opcode <= op.Decrement) || compare(opcode)
+}
+
+// compare checks if the opcode compares the values it pops. A comparison
+// converts its operands to floats rather than integers.
+func compare(opcode op.Op) bool {
+	return opcode >= op.Eq && opcode <= op.Cmp
+}
+
+// evaluate runs the instructions on the reference VM and returns the push of
+// the value they leave on the stack. The second return value is false if they
+// fail, or if they convert a float which is out of range to an integer.
+func evaluate(insts []op.Instruction, strict bool) (op.Instruction, bool) {
+	var code op.ByteCode
+	last := insts[len(insts)-1]
+	for _, inst := range insts[:len(insts)-1] {
+		code = inst.Append(code)
+	}
+	pushes := len(code)
+	code = last.Append(code)
+
+	vm := reference.New(vmopt.WithStrict(strict))
+	if err := vm.Execute(code); err != nil || len(vm.Stack) != 1 {
+		return op.Instruction{}, false
+	}
+	if _, float := vm.Stack[0].(float64); !strict && !float && !compare(last.Op) {
+		operands := reference.New()
+		if err := operands.Execute(code[:pushes]); err != nil {
+			return op.Instruction{}, false
+		}
+		// Go leaves the conversion of a float which is out of range of the
+		// integer type to the platform
+		for _, value := range operands.Stack {
+			if v, ok := value.(float64); ok && !(v > -1 && v < math.MaxInt64) {
+				return op.Instruction{}, false
+			}
+		}
+	}
+	return push(vm.Stack[0])
+}
+
+// push returns the smallest instruction pushing the value.
+func push(value interface{}) (op.Instruction, bool) {
+	switch v := value.(type) {
+	case int64:
+		if v == int64(int32(v)) {
+			return op.Instruction{Op: op.PushInt32, Args: []uint64{uint64(uint32(int32(v)))}}, true
+		}
+		return op.Instruction{Op: op.PushInt64, Args: []uint64{uint64(v)}}, true
+	case uint64:
+		if v <= math.MaxUint32 {
+			return op.Instruction{Op: op.PushUint32, Args: []uint64{v}}, true
+		}
+		return op.Instruction{Op: op.PushUint64, Args: []uint64{v}}, true
+	case float64:
+		// The value must survive the round trip through a float32 bit for
+		// bit, including the payload of a NaN
+		bits := math.Float32bits(float32(v))
+		if math.Float64bits(float64(math.Float32frombits(bits))) == math.Float64bits(v) {
+			return op.Instruction{Op: op.PushFloat32, Args: []uint64{uint64(bits)}}, true
+		}
+		return op.Instruction{Op: op.PushFloat64, Args: []uint64{math.Float64bits(v)}}, true
+	}
+	return op.Instruction{}, false
+}
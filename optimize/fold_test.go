@@ -0,0 +1,174 @@
+package optimize_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/optimize"
+	"github.com/tvarney/gotvm/verify"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+func TestFold(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Source   string
+		Expected string
+	}{
+		{"empty", "", ""},
+		{"add", "PushI32 16\nPushI32 64\nAddInt", "PushI32 80"},
+		{"expression", "PushI32 0x10\nPushI32 0x40\nPushI32 -10\nAddInt\nAddInt", "PushI32 70"},
+		{"const", "PushI32 3\nAddConstI32 4\nMulConstI64 2", "PushI32 14"},
+		{"sub-order", "PushI32 3\nPushI32 10\nSubInt", "PushI32 7"},
+		{"narrow", "PushI64 1\nPushI64 2\nAddInt", "PushI32 3"},
+		{"widen", "PushI32 0x7FFFFFFF\nIncrement", "PushI64 0x80000000"},
+		{"wrap", "PushI64 0x7FFFFFFFFFFFFFFF\nIncrement", "PushI64 -9223372036854775808"},
+		{"uint", "PushU32 0xFFFFFFFF\nAddConstU32 1", "PushU64 0x100000000"},
+		{"negative-uint", "PushU32 1\nNegative", "PushU64 0xFFFFFFFFFFFFFFFF"},
+		{"float", "PushF64 0.1\nAddConstF64 0.2", "PushF64 0.30000000000000004"},
+		{"float-narrow", "PushF64 0.5\nMulConstF32 2", "PushF32 1"},
+		{"float-inf", "PushF32 1\nDivConstF32 0", "PushF32 0x7F800000"},
+		{"compare", "PushF32 1.5\nPushF32 2\nLt", "PushI32 1"},
+		{"cmp", "PushU32 1\nPushU32 2\nCmp", "PushI32 -1"},
+		{"div-zero", "PushI32 0\nPushI32 1\nDivInt", "PushI32 0\nPushI32 1\nDivInt"},
+		{"div-const-zero", "PushI32 1\nDivConstU32 0", "PushI32 1\nDivConstU32 0"},
+		{"overflow", "PushI64 -9223372036854775808\nDivConstI32 -1", "PushI64 -9223372036854775808\nDivConstI32 -1"},
+		{"coercion", "PushU32 1\nPushI32 2\nAddInt", "PushU32 1\nPushI32 2\nAddInt"},
+		{"compare-types", "PushI32 1\nPushU32 1\nEq", "PushI32 1\nPushU32 1\nEq"},
+		{"partial", "PushI32 1\nPushU32 2\nPushU32 3\nAddConstU32 1\nMulConstU32 2", "PushI32 1\nPushU32 2\nPushU32 8"},
+		{"not-pure", "PushI32 1\nPushI32 2\nSwap 0", "PushI32 1\nPushI32 2\nSwap 0"},
+		{
+			"target-inside",
+			"PushI32 7\nPushI32 0\nJumpIfZero two\nPushI32 1\ntwo:\nPushI32 2\nAddInt",
+			"PushI32 7\nPushI32 0\nJumpIfZero two\nPushI32 1\ntwo:\nPushI32 2\nAddInt",
+		},
+		{
+			"target-first",
+			"PushI32 0\nJumpIfZero expr\nexpr:\nPushI32 1\nPushI32 2\nAddInt",
+			"PushI32 0\nJumpIfZero expr\nexpr:\nPushI32 3",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			code := vmtest.Assemble(t, test.Source)
+			folded, debug, err := optimize.Fold(code, nil, true)
+			require.NoError(t, err)
+			assert.Equal(t, vmtest.Assemble(t, test.Expected), folded)
+			assert.Nil(t, debug)
+			equivalent(t, code, folded)
+		})
+	}
+}
+
+func TestFoldCoercion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Source   string
+		Expected string
+	}{
+		{"coercion", "PushU32 1\nPushI32 2\nAddInt", "PushI32 3"},
+		{"uint", "PushI32 -1\nAddConstU32 1", "PushU32 0"},
+		{"float", "PushI32 1\nAddConstF32 0.5", "PushF32 1.5"},
+		{"float-to-int", "PushF32 2.5\nPushI32 1\nAddInt", "PushI32 3"},
+		{"compare-types", "PushI32 1\nPushU32 1\nEq", "PushI32 1"},
+		{"compare-float", "PushF64 1e300\nPushI32 1\nLt", "PushI32 0"},
+		{"float-out-of-range", "PushF64 1e300\nPushI32 1\nAddInt", "PushF64 1e300\nPushI32 1\nAddInt"},
+		{"float-to-uint", "PushF32 -1\nAddConstU32 1", "PushF32 -1\nAddConstU32 1"},
+		{"nan-to-int", "PushF32 nan\nIncrement\nPushI32 1\nMulInt", "PushF32 nan\nPushI32 1\nMulInt"},
+		{"div-zero", "PushU32 0\nPushI32 1\nDivInt", "PushU32 0\nPushI32 1\nDivInt"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			code := vmtest.Assemble(t, test.Source)
+			folded, _, err := optimize.Fold(code, nil, false)
+			require.NoError(t, err)
+			assert.Equal(t, vmtest.Assemble(t, test.Expected), folded)
+			equivalentIn(t, code, folded, false)
+		})
+	}
+}
+
+func TestFoldDebugInfo(t *testing.T) {
+	t.Parallel()
+
+	lines := strings.Split("PushI32 1\nvalue:\nPushI32 2\nPushI32 3\nMulInt\nAddInt\nend:\nHalt", "\n")
+	code, debug := assembler.AssembleDebug(lines, nil)
+	folded, moved, err := optimize.Fold(code, debug, true)
+	require.NoError(t, err)
+	assert.Equal(t, op.ByteCode{op.PushInt32, 7, op.Halt}, folded)
+	assert.Equal(t, map[int]int{0: 1, 2: 8}, moved.Lines)
+	assert.Equal(t, map[int]string{0: "value", 2: "end"}, moved.Symbols)
+}
+
+func TestFoldRejected(t *testing.T) {
+	t.Parallel()
+
+	folded, debug, err := optimize.Fold(op.ByteCode{op.Jump, 5}, nil, true)
+	assert.ErrorIs(t, err, verify.ErrInvalidTarget)
+	assert.Nil(t, folded)
+	assert.Nil(t, debug)
+}
+
+func TestFoldExamples(t *testing.T) {
+	t.Parallel()
+
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*.asm"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			t.Parallel()
+
+			content, err := os.ReadFile(file)
+			require.NoError(t, err)
+			code := assembler.Assemble(strings.Split(string(content), "\n"), nil)
+			for _, strict := range []bool{false, true} {
+				folded, _, err := optimize.Fold(code, nil, strict)
+				require.NoError(t, err)
+				assert.LessOrEqual(t, len(folded), len(code))
+				equivalentIn(t, code, folded, strict)
+			}
+		})
+	}
+}
+
+func FuzzFold(f *testing.F) {
+	for _, code := range vmtest.Corpus(f) {
+		f.Add(vmtest.BytesFromCode(code), false)
+	}
+	f.Add([]byte{}, true)
+
+	f.Fuzz(func(t *testing.T, data []byte, strict bool) {
+		code := vmtest.CodeFromBytes(data)
+		folded, _, err := optimize.Fold(code, nil, strict)
+		if err != nil {
+			return
+		}
+		if strict {
+			// Code folded in strict mode runs the same in either mode
+			equivalent(t, code, folded)
+		} else {
+			equivalentIn(t, code, folded, false)
+		}
+	})
+}
@@ -0,0 +1,283 @@
+// Package optimize rewrites bytecode into shorter bytecode which behaves the
+// same.
+//
+// The optimizer is a peephole optimizer; it looks at short runs of adjacent
+// instructions and replaces them with fewer or smaller instructions:
+//
+//   - Noop is removed
+//   - A value pushed and then popped is never pushed
+//   - `PushI32 N; AddInt` and `PushI64 N; AddInt` become AddConstI32 and
+//     AddConstI64, and likewise for MulInt
+//   - Adding or subtracting a constant one becomes Increment or Decrement
+//   - `Increment; Decrement` on an integer, and `Negative; Negative`, are
+//     removed
+//
+// Rewrites which depend on the type of a value use the types inferred by the
+// typecheck package, and are only made where the type is known. A run of
+// instructions is only rewritten if no branch lands inside of it, and the
+// targets of every branch and the debug info are moved with the code.
+//
+// The optimized program leaves the same values on the stack, and fails with
+// the same errors, as the original. It runs fewer instructions and uses less
+// of the stack, so it uses less gas, and may finish where the original would
+// run out of gas or overflow the stack. An error raised by a rewritten
+// instruction is reported at the offset of the instruction which replaced it.
+package optimize
+
+import (
+	"math"
+	"sort"
+
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/typecheck"
+)
+
+// rewrite is a replacement for a run of instructions.
+type rewrite struct {
+	Count   int              // The number of instructions replaced
+	Replace []op.Instruction // The instructions they are replaced with
+}
+
+// unit is a const opcode with the operand which adds or subtracts one.
+type unit struct {
+	Op  op.Op
+	Arg uint64
+}
+
+// units maps a const opcode adding or subtracting one to the type it must be
+// given to be replaced, and the Increment or Decrement which replaces it.
+// Increment and Decrement keep the type of their operand, so the const
+// opcodes can only be replaced where they would not convert the value.
+var units = map[unit]struct {
+	Type typecheck.Type
+	Op   op.Op
+}{
+	{op.AddConstInt32, 1}:                              {typecheck.TypeInt, op.Increment},
+	{op.AddConstInt32, math.MaxUint32}:                 {typecheck.TypeInt, op.Decrement},
+	{op.AddConstInt64, 1}:                              {typecheck.TypeInt, op.Increment},
+	{op.AddConstInt64, math.MaxUint64}:                 {typecheck.TypeInt, op.Decrement},
+	{op.AddConstUint32, 1}:                             {typecheck.TypeUint, op.Increment},
+	{op.AddConstUint64, 1}:                             {typecheck.TypeUint, op.Increment},
+	{op.AddConstUint64, math.MaxUint64}:                {typecheck.TypeUint, op.Decrement},
+	{op.AddConstFloat32, uint64(math.Float32bits(1))}:  {typecheck.TypeFloat, op.Increment},
+	{op.AddConstFloat32, uint64(math.Float32bits(-1))}: {typecheck.TypeFloat, op.Decrement},
+	{op.AddConstFloat64, math.Float64bits(1)}:          {typecheck.TypeFloat, op.Increment},
+	{op.AddConstFloat64, math.Float64bits(-1)}:         {typecheck.TypeFloat, op.Decrement},
+	{op.SubConstInt32, 1}:                              {typecheck.TypeInt, op.Decrement},
+	{op.SubConstInt32, math.MaxUint32}:                 {typecheck.TypeInt, op.Increment},
+	{op.SubConstInt64, 1}:                              {typecheck.TypeInt, op.Decrement},
+	{op.SubConstInt64, math.MaxUint64}:                 {typecheck.TypeInt, op.Increment},
+	{op.SubConstUint32, 1}:                             {typecheck.TypeUint, op.Decrement},
+	{op.SubConstUint64, 1}:                             {typecheck.TypeUint, op.Decrement},
+	{op.SubConstUint64, math.MaxUint64}:                {typecheck.TypeUint, op.Increment},
+	{op.SubConstFloat32, uint64(math.Float32bits(1))}:  {typecheck.TypeFloat, op.Decrement},
+	{op.SubConstFloat32, uint64(math.Float32bits(-1))}: {typecheck.TypeFloat, op.Increment},
+	{op.SubConstFloat64, math.Float64bits(1)}:          {typecheck.TypeFloat, op.Decrement},
+	{op.SubConstFloat64, math.Float64bits(-1)}:         {typecheck.TypeFloat, op.Increment},
+}
+
+// fused maps a push and a binary opcode to the const opcode doing both.
+//
+// Only addition and multiplication are fused; the binary opcodes take the
+// topmost value as their first operand, so `PushI32 N; SubInt` computes N - x
+// where SubConstI32 computes x - N.
+var fused = map[[2]op.Op]op.Op{
+	{op.PushInt32, op.AddInt}: op.AddConstInt32,
+	{op.PushInt64, op.AddInt}: op.AddConstInt64,
+	{op.PushInt32, op.MulInt}: op.MulConstInt32,
+	{op.PushInt64, op.MulInt}: op.MulConstInt64,
+}
+
+// rule finds the rewrite for the instructions at the start of insts. The
+// second return value is false if there is none.
+type rule func(insts []op.Instruction, targets map[int]bool, result *typecheck.Result) (rewrite, bool)
+
+// Optimize returns an optimized copy of the code, and of its debug info if it
+// is not nil. Rewrites are made until none apply. Arithmetic on constants is
+// not computed; that is done by Fold, which is run as a pass of its own.
+//
+// The code must pass verify.Verify; the optimizer relies on a verified program
+// never popping a value which is not there. If the code is rejected, the
+// error from typecheck.Infer is returned.
+func Optimize(code op.ByteCode, debug *op.DebugInfo) (op.ByteCode, *op.DebugInfo, error) {
+	return run(code, debug, match)
+}
+
+// run makes passes over the code with the rule until nothing changes.
+func run(code op.ByteCode, debug *op.DebugInfo, r rule) (op.ByteCode, *op.DebugInfo, error) {
+	for {
+		result, err := typecheck.Infer(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		next, nextDebug, changed := pass(code, debug, result, r)
+		if !changed {
+			return code, debug, nil
+		}
+		code, debug = next, nextDebug
+	}
+}
+
+// pass makes every rewrite which applies to the code in a single pass.
+func pass(code op.ByteCode, debug *op.DebugInfo, result *typecheck.Result, r rule) (op.ByteCode, *op.DebugInfo, bool) {
+	var insts []op.Instruction
+	targets := map[int]bool{}
+	for offset := 0; offset < len(code); {
+		inst, _ := op.Decode(code, offset)
+		insts = append(insts, inst)
+		if target, ok := inst.Target(); ok {
+			targets[target] = true
+		}
+		offset = inst.Next()
+	}
+
+	// offsets maps the offset of each instruction in the original code to
+	// its offset in the new code; an instruction which was removed maps to
+	// the instruction after it
+	offsets := make(map[int]int, len(insts)+1)
+	type emitted struct {
+		Inst   op.Instruction
+		Target int // The target of a branch in the original code
+		Branch bool
+	}
+	out := make([]emitted, 0, len(insts))
+	lines := map[int]int{}
+	changed := false
+	size := 0
+	for idx := 0; idx < len(insts); {
+		rw, ok := r(insts[idx:], targets, result)
+		if !ok {
+			rw = rewrite{Count: 1, Replace: insts[idx : idx+1]}
+		}
+		changed = changed || ok
+
+		for _, inst := range insts[idx : idx+rw.Count] {
+			offsets[inst.Offset] = size
+		}
+		if line := debug.Line(insts[idx].Offset); line != 0 && len(rw.Replace) > 0 {
+			lines[size] = line
+		}
+		for _, inst := range rw.Replace {
+			e := emitted{Inst: inst}
+			e.Target, e.Branch = inst.Target()
+			e.Inst.Offset = size
+			e.Inst.Args = append([]uint64(nil), inst.Args...)
+			out = append(out, e)
+			size += inst.Size()
+		}
+		idx += rw.Count
+	}
+	if !changed {
+		return code, debug, false
+	}
+	offsets[len(code)] = size
+
+	optimized := make(op.ByteCode, 0, size)
+	for _, e := range out {
+		if e.Branch {
+			retarget(&e.Inst, offsets[e.Target])
+		}
+		optimized = e.Inst.Append(optimized)
+	}
+	if len(optimized) == 0 {
+		optimized = nil
+	}
+	if debug == nil {
+		return optimized, nil, true
+	}
+
+	moved := op.NewDebugInfo()
+	moved.Lines = lines
+	symbols := make([]int, 0, len(debug.Symbols))
+	for offset := range debug.Symbols {
+		symbols = append(symbols, offset)
+	}
+	sort.Ints(symbols)
+	for _, offset := range symbols {
+		// Labels on removed instructions move to the instruction after them;
+		// if that already has a label, the first is kept
+		to, ok := offsets[offset]
+		if _, taken := moved.Symbols[to]; ok && !taken {
+			moved.Symbols[to] = debug.Symbols[offset]
+		}
+	}
+	return optimized, moved, true
+}
+
+// retarget sets the offset operand of the instruction so that it branches to
+// the target.
+func retarget(inst *op.Instruction, target int) {
+	meta, _ := op.Info(inst.Op)
+	for n, operand := range meta.Operands {
+		if operand == op.OperandOffset {
+			inst.Args[n] = uint64(uint32(int32(target - inst.Offset)))
+		}
+	}
+}
+
+// match is the rule of Optimize. A rewrite of several instructions is only
+// made if no branch lands after the first of them.
+func match(insts []op.Instruction, targets map[int]bool, result *typecheck.Result) (rewrite, bool) {
+	inst := insts[0]
+	top := topType(result.Annotations[inst.Offset])
+	if inst.Op == op.Noop {
+		return rewrite{Count: 1}, true
+	}
+
+	if len(insts) > 1 && !targets[insts[1].Offset] {
+		next := insts[1]
+		if pushes(inst.Op) {
+			switch next.Op {
+			case op.Pop:
+				return rewrite{Count: 2}, true
+			case op.PopN:
+				switch n := uint32(next.Args[0]); {
+				case n == 1:
+					return rewrite{Count: 2}, true
+				case n > 1:
+					return rewrite{Count: 2, Replace: []op.Instruction{{Op: op.PopN, Args: []uint64{uint64(n - 1)}}}}, true
+				}
+			}
+		}
+		if opcode, ok := fused[[2]op.Op{inst.Op, next.Op}]; ok {
+			return rewrite{Count: 2, Replace: []op.Instruction{{Op: opcode, Args: inst.Args}}}, true
+		}
+		switch [2]op.Op{inst.Op, next.Op} {
+		case [2]op.Op{op.Increment, op.Decrement}, [2]op.Op{op.Decrement, op.Increment}:
+			// Adding and subtracting one may round a float
+			if top == typecheck.TypeInt || top == typecheck.TypeUint {
+				return rewrite{Count: 2}, true
+			}
+		case [2]op.Op{op.Negative, op.Negative}:
+			if top != typecheck.TypeUnknown {
+				return rewrite{Count: 2}, true
+			}
+		}
+	}
+
+	if len(inst.Args) == 1 {
+		if u, ok := units[unit{inst.Op, inst.Args[0]}]; ok && top == u.Type {
+			return rewrite{Count: 1, Replace: []op.Instruction{{Op: u.Op}}}, true
+		}
+	}
+	return rewrite{}, false
+}
+
+// pushes checks if the opcode only pushes a value, and so can be removed
+// together with a Pop after it. Copy can't fail in a verified program.
+func pushes(opcode op.Op) bool {
+	switch opcode {
+	case op.PushInt32, op.PushInt64, op.PushUint32, op.PushUint64, op.PushFloat32, op.PushFloat64, op.Copy:
+		return true
+	}
+	return false
+}
+
+// topType returns the type of the topmost value the annotated instruction
+// takes from the stack.
+func topType(ann typecheck.Annotation) typecheck.Type {
+	if len(ann.Pops) == 0 {
+		return typecheck.TypeUnknown
+	}
+	return ann.Pops[len(ann.Pops)-1]
+}
@@ -0,0 +1,209 @@
+package optimize_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/optimize"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+func TestOptimize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Source   string
+		Expected string
+	}{
+		{"empty", "", ""},
+		{"unchanged", "PushI32 1\nPushI32 2\nSubInt", "PushI32 1\nPushI32 2\nSubInt"},
+		{"noop", "Noop\nPushI32 1\nNoop\nNoop", "PushI32 1"},
+		{"push-pop", "PushI32 1\nPushF64 2\nPop", "PushI32 1"},
+		{"copy-pop", "PushI32 1\nCopy 0\nPop", "PushI32 1"},
+		{"push-popn", "PushI32 1\nPushI32 2\nPushU32 3\nPopN 2", "PushI32 1"},
+		{"push-popn-one", "PushI32 1\nPushI32 2\nPopN 1", "PushI32 1"},
+		{"add-const", "PushU32 1\nPushI32 5\nAddInt", "PushU32 1\nAddConstI32 5"},
+		{"add-const-64", "PushU32 1\nPushI64 5\nAddInt", "PushU32 1\nAddConstI64 5"},
+		{"mul-const", "PushU32 1\nPushI32 5\nMulInt", "PushU32 1\nMulConstI32 5"},
+		{"sub-not-fused", "PushU32 1\nPushI32 5\nSubInt", "PushU32 1\nPushI32 5\nSubInt"},
+		{"add-one", "PushI32 1\nPushI32 1\nAddInt", "PushI32 1\nIncrement"},
+		{"add-one-uint", "PushU32 1\nAddConstU32 1", "PushU32 1\nIncrement"},
+		{"add-minus-one", "PushI32 1\nAddConstI64 -1", "PushI32 1\nDecrement"},
+		{"sub-one", "PushU32 1\nSubConstU64 1", "PushU32 1\nDecrement"},
+		{"sub-one-float", "PushF32 1\nSubConstF64 1.0", "PushF32 1\nDecrement"},
+		{"add-one-wrong-type", "PushU32 1\nAddConstI32 1", "PushU32 1\nAddConstI32 1"},
+		{"add-one-unknown-type", "PushI32 1\nNativeCall 0 0\nAddConstI32 1", "PushI32 1\nNativeCall 0 0\nAddConstI32 1"},
+		{"inc-dec", "PushI32 1\nIncrement\nDecrement", "PushI32 1"},
+		{"dec-inc-uint", "PushU32 1\nDecrement\nIncrement", "PushU32 1"},
+		{"inc-dec-float", "PushF32 1\nIncrement\nDecrement", "PushF32 1\nIncrement\nDecrement"},
+		{"negative", "PushF32 1\nNegative\nNegative", "PushF32 1"},
+		{"repeated", "PushI32 1\nPushI32 1\nNoop\nAddInt\nPushI32 -1\nAddInt", "PushI32 1"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			code := vmtest.Assemble(t, test.Source)
+			optimized, debug, err := optimize.Optimize(code, nil)
+			require.NoError(t, err)
+			assert.Equal(t, vmtest.Assemble(t, test.Expected), optimized)
+			assert.Nil(t, debug)
+			equivalent(t, code, optimized)
+		})
+	}
+}
+
+func TestBranches(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Source   string
+		Expected string
+	}{
+		{
+			"jump-over-removed",
+			"PushI32 0\nJumpIfZero end\nNoop\nPushI32 1\nPop\nend:\nPushI32 2",
+			"PushI32 0\nJumpIfZero end\nend:\nPushI32 2",
+		},
+		{
+			"jump-back",
+			"PushI32 3\nloop:\nNoop\nDecrement\nCopy 0\nJumpIfNotZero loop",
+			"PushI32 3\nloop:\nDecrement\nCopy 0\nJumpIfNotZero loop",
+		},
+		{
+			"target-removed",
+			"PushI32 0\nJumpIfZero skip\nPushI32 1\nskip:\nNoop\nHalt",
+			"PushI32 0\nJumpIfZero skip\nPushI32 1\nskip:\nHalt",
+		},
+		{
+			"target-inside",
+			"PushI32 1\nPushI32 0\nPushI32 0\nJumpIfZero add\nPushI32 2\nadd:\nAddInt",
+			"PushI32 1\nPushI32 0\nPushI32 0\nJumpIfZero add\nPushI32 2\nadd:\nAddInt",
+		},
+		{
+			"target-first",
+			"PushI32 2\nPushI32 0\nJumpIfZero add\nNoop\nadd:\nPushI32 2\nAddInt",
+			"PushI32 2\nPushI32 0\nJumpIfZero add\nadd:\nAddConstI32 2",
+		},
+		{
+			"call",
+			"PushI32 1\nCall f 1\nHalt\nNoop\nf:\nPushI32 2\nMulInt\nReturn 1",
+			"PushI32 1\nCall f 1\nHalt\nf:\nMulConstI32 2\nReturn 1",
+		},
+		{
+			"jump-to-end",
+			"PushI32 0\nJumpIfZero end\nNoop\nend:",
+			"PushI32 0\nJumpIfZero end\nend:",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			code := vmtest.Assemble(t, test.Source)
+			optimized, _, err := optimize.Optimize(code, nil)
+			require.NoError(t, err)
+			assert.Equal(t, vmtest.Assemble(t, test.Expected), optimized)
+			equivalent(t, code, optimized)
+		})
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	t.Parallel()
+
+	lines := strings.Split("start:\nNoop\nPushI32 1\nPushI32 2\nAddInt\nlabel:\nNoop\nother:\nHalt", "\n")
+	code, debug := assembler.AssembleDebug(lines, nil)
+	optimized, moved, err := optimize.Optimize(code, debug)
+	require.NoError(t, err)
+	assert.Equal(t, op.ByteCode{op.PushInt32, 1, op.AddConstInt32, 2, op.Halt}, optimized)
+	assert.Equal(t, map[int]int{0: 3, 2: 4, 4: 9}, moved.Lines)
+	assert.Equal(t, map[int]string{0: "start", 4: "label"}, moved.Symbols)
+
+	// The original debug info is left as it was
+	assert.Equal(t, map[int]string{0: "start", 6: "label", 7: "other"}, debug.Symbols)
+}
+
+func TestRejected(t *testing.T) {
+	t.Parallel()
+
+	optimized, debug, err := optimize.Optimize(op.ByteCode{op.PushInt32, 1, op.AddInt}, op.NewDebugInfo())
+	assert.ErrorIs(t, err, vmerr.ErrTooFewValues)
+	assert.Nil(t, optimized)
+	assert.Nil(t, debug)
+}
+
+// fuzzSteps is the instruction budget for programs run by the tests
+const fuzzSteps = 10000
+
+// equivalent checks that the optimized code gives the same result as the
+// original on the reference VM, both in strict mode and not.
+func equivalent(t *testing.T, code, optimized op.ByteCode) {
+	t.Helper()
+
+	for _, strict := range []bool{false, true} {
+		equivalentIn(t, code, optimized, strict)
+	}
+}
+
+// equivalentIn checks that the optimized code gives the same result as the
+// original on the reference VM with the given strictness. The gas used is not
+// compared, and programs which don't finish are not checked.
+func equivalentIn(t *testing.T, code, optimized op.ByteCode, strict bool) {
+	t.Helper()
+
+	want, done := vmtest.RunReferenceSteps(code, fuzzSteps, vmopt.WithStrict(strict))
+	if !done {
+		return
+	}
+	got, done := vmtest.RunReferenceSteps(optimized, fuzzSteps, vmopt.WithStrict(strict))
+	if !assert.True(t, done, "optimized %v does not finish; original %v does", optimized, code) {
+		return
+	}
+	want.GasUsed, got.GasUsed = 0, 0
+	assert.Empty(t, vmtest.Diff(got, want), "optimized %v differs from %v with strict %v", optimized, code, strict)
+}
+
+func TestCorpus(t *testing.T) {
+	t.Parallel()
+
+	for name, code := range vmtest.Corpus(t) {
+		code := code
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			optimized, _, err := optimize.Optimize(code, nil)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(optimized), len(code))
+			equivalent(t, code, optimized)
+		})
+	}
+}
+
+func FuzzOptimize(f *testing.F) {
+	for _, code := range vmtest.Corpus(f) {
+		f.Add(vmtest.BytesFromCode(code))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		code := vmtest.CodeFromBytes(data)
+		optimized, _, err := optimize.Optimize(code, nil)
+		if err != nil {
+			return
+		}
+		assert.LessOrEqual(t, len(optimized), len(code))
+		equivalent(t, code, optimized)
+	})
+}
@@ -1,6 +1,8 @@
 package reference
 
 import (
+	"context"
+
 	"github.com/tvarney/gotvm/op"
 	"github.com/tvarney/gotvm/vmerr"
 )
@@ -15,8 +17,22 @@ const (
 // Under the hood this calls the (*VirtualMachine).Start() function with the
 // code, then calls (*VirtualMachine).Step() until it returns an error.
 func (vm *VirtualMachine) Execute(code op.ByteCode) error {
+	return vm.ExecuteContext(context.Background(), code)
+}
+
+// ExecuteContext runs the ByteCode like Execute, but stops with a
+// vmerr.InterruptedError wrapping ctx.Err() if the context is done. The
+// context is checked before the first opcode and then after every
+// ContextCheckInterval opcodes.
+func (vm *VirtualMachine) ExecuteContext(ctx context.Context, code op.ByteCode) error {
 	vm.Start(code)
-	for {
+	for steps := 0; ; steps++ {
+		if steps%ContextCheckInterval == 0 && vm.idx >= 0 && vm.idx < len(vm.code) {
+			if err := ctx.Err(); err != nil {
+				err = vmerr.InterruptedError{OpCode: vm.code[vm.idx].String(), Offset: vm.idx, Err: err}
+				return vm.fail(vm.runtimeError(vm.idx, vm.top(len(vm.Stack)), err))
+			}
+		}
 		if err := vm.Step(); err != nil {
 			if err == ErrHalt {
 				return nil
@@ -30,16 +46,47 @@ func (vm *VirtualMachine) Execute(code op.ByteCode) error {
 func (vm *VirtualMachine) Start(code op.ByteCode) {
 	vm.code = code
 	vm.idx = 0
+	vm.FrameBase = 0
+	vm.Frames = vm.Frames[:0]
+	vm.GasUsed = 0
 }
 
 // Step executes the next opcode in the code that the VirtualMachine instance
 // is running.
+//
+// ErrHalt is returned once the program has finished; any other error is a
+// vmerr.RuntimeError recording where the program failed.
 func (vm *VirtualMachine) Step() error {
 	if vm.idx < 0 || vm.idx >= len(vm.code) {
+		vm.halt()
 		return ErrHalt
 	}
+	// Opcodes may pop values before failing, so the depth of the stack is
+	// saved for the error first
+	offset, depth := vm.idx, len(vm.Stack)
+	switch err := vm.step(); err {
+	case nil:
+		return nil
+	case ErrHalt:
+		vm.halt()
+		return ErrHalt
+	default:
+		return vm.fail(vm.runtimeError(offset, vm.top(depth), err))
+	}
+}
+
+// step implements Step for an offset inside the code.
+func (vm *VirtualMachine) step() error {
+	opcode := vm.code[vm.idx]
+	if vm.GasLimit != 0 && vm.GasUsed+opcode.Cost() > vm.GasLimit {
+		return vmerr.OutOfGasError{OpCode: opcode.String(), Offset: vm.idx}
+	}
+	vm.GasUsed += opcode.Cost()
+	if vm.Tracer != nil {
+		vm.Tracer.OnInstruction(vm.idx, opcode, (*stackView)(vm))
+	}
 
-	switch vm.code[vm.idx] {
+	switch opcode {
 	case op.Noop:
 		vm.idx++
 	case op.Halt:
@@ -127,6 +174,44 @@ func (vm *VirtualMachine) Step() error {
 		return vm.OpIncrement()
 	case op.Decrement:
 		return vm.OpDecrement()
+	case op.Call:
+		return vm.OpCall()
+	case op.Return:
+		return vm.OpReturn()
+	case op.Jump:
+		return vm.OpJump()
+	case op.JumpIfZero:
+		return vm.OpJumpIfZero()
+	case op.JumpIfNotZero:
+		return vm.OpJumpIfNotZero()
+	case op.JumpIfZeroInt:
+		return vm.OpJumpIfZeroInt()
+	case op.JumpIfZeroUint:
+		return vm.OpJumpIfZeroUint()
+	case op.JumpIfZeroFloat:
+		return vm.OpJumpIfZeroFloat()
+	case op.JumpIfNotZeroInt:
+		return vm.OpJumpIfNotZeroInt()
+	case op.JumpIfNotZeroUint:
+		return vm.OpJumpIfNotZeroUint()
+	case op.JumpIfNotZeroFloat:
+		return vm.OpJumpIfNotZeroFloat()
+	case op.Eq:
+		return vm.OpEq()
+	case op.Ne:
+		return vm.OpNe()
+	case op.Lt:
+		return vm.OpLt()
+	case op.Le:
+		return vm.OpLe()
+	case op.Gt:
+		return vm.OpGt()
+	case op.Ge:
+		return vm.OpGe()
+	case op.Cmp:
+		return vm.OpCmp()
+	case op.NativeCall:
+		return vm.OpNativeCall()
 	default:
 		return vmerr.InvalidOpcodeError{OpCode: uint32(vm.code[vm.idx])}
 	}
@@ -0,0 +1,108 @@
+package reference_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/reference"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+)
+
+// The opcodes are tested on both VMs by the tables in the main package, which
+// are checked against the reference VM. The tests here cover the API of the
+// reference VM.
+
+// b returns the opcodes and operands as bytecode.
+func b(values ...op.Op) op.ByteCode {
+	return values
+}
+
+// s returns the values as a stack.
+func s(values ...interface{}) []interface{} {
+	return append([]interface{}{}, values...)
+}
+
+func TestExecuteContext(t *testing.T) {
+	t.Parallel()
+
+	loop := b(op.Noop, op.Jump, 0xFFFFFFFF)
+
+	t.Run("completes", func(t *testing.T) {
+		t.Parallel()
+
+		vm := reference.New()
+		err := vm.ExecuteContext(context.Background(), b(op.PushInt32, 1, op.Halt))
+		assert.NoError(t, err)
+		assert.Len(t, vm.Stack, 1)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		vm := reference.New()
+		err := vm.ExecuteContext(ctx, b(op.PushInt32, 1, op.Halt))
+		assert.Equal(t, vmerr.InterruptedError{OpCode: "PushI32", Offset: 0, Err: context.Canceled}, errors.Unwrap(err))
+		assert.Empty(t, vm.Stack)
+	})
+	t.Run("deadline", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		vm := reference.New()
+		err := vm.ExecuteContext(ctx, loop)
+		assert.ErrorIs(t, err, vmerr.ErrInterrupted)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestNativeCall(t *testing.T) {
+	t.Parallel()
+
+	natives := vmopt.WithNatives(vmopt.Native{Name: "sum", Func: func(args []interface{}) ([]interface{}, error) {
+		total := int64(0)
+		for _, arg := range args {
+			total += arg.(int64)
+		}
+		return []interface{}{total, int64(len(args))}, nil
+	}})
+
+	// The results are checked against MaxStack before the arguments are
+	// replaced, so the stack is left as it was
+	vm := reference.New(natives, vmopt.WithMaxStack(3))
+	err := vm.Execute(b(op.PushInt32, 7, op.PushInt32, 2, op.PushInt32, 3, op.NativeCall, 0, 1))
+	assert.Equal(t, vmerr.StackOverflowError{OpCode: "NativeCall"}, errors.Unwrap(err))
+	assert.Equal(t, s(int64(7), int64(2), int64(3)), vm.Stack)
+}
+
+func TestOptions(t *testing.T) {
+	t.Parallel()
+
+	vm := reference.New(
+		vmopt.WithStackSize(16),
+		vmopt.WithMaxStack(32),
+		vmopt.WithMaxFrames(4),
+		vmopt.WithGasLimit(100),
+		vmopt.WithStrict(true),
+	)
+	assert.Equal(t, 16, cap(vm.Stack))
+	assert.Equal(t, 32, vm.MaxStack)
+	assert.Equal(t, 4, vm.MaxFrames)
+	assert.Equal(t, uint64(100), vm.GasLimit)
+	assert.True(t, vm.Strict)
+
+	vm = reference.New()
+	assert.Equal(t, vmopt.DefaultStackSize, cap(vm.Stack))
+	assert.Zero(t, vm.MaxStack)
+	assert.Zero(t, vm.MaxFrames)
+	assert.Zero(t, vm.GasLimit)
+	assert.False(t, vm.Strict)
+}
@@ -14,10 +14,13 @@ import (
 func (vm *VirtualMachine) OpPushInt32() error {
 	value, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.PushInt32.String()}
+	}
+	if err := vm.CheckStack(op.PushInt32); err != nil {
 		return err
 	}
 	vm.Push(int64(int32(value)))
-	vm.idx += 2
+	vm.idx += op.PushInt32.Size()
 	return nil
 }
 
@@ -28,10 +31,13 @@ func (vm *VirtualMachine) OpPushInt32() error {
 func (vm *VirtualMachine) OpPushInt64() error {
 	value, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.PushInt64.String()}
+	}
+	if err := vm.CheckStack(op.PushInt64); err != nil {
 		return err
 	}
 	vm.Push(int64(value))
-	vm.idx += 3
+	vm.idx += op.PushInt64.Size()
 	return nil
 }
 
@@ -42,10 +48,13 @@ func (vm *VirtualMachine) OpPushInt64() error {
 func (vm *VirtualMachine) OpPushUint32() error {
 	value, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.PushUint32.String()}
+	}
+	if err := vm.CheckStack(op.PushUint32); err != nil {
 		return err
 	}
 	vm.Push(uint64(value))
-	vm.idx += 2
+	vm.idx += op.PushUint32.Size()
 	return nil
 }
 
@@ -56,10 +65,13 @@ func (vm *VirtualMachine) OpPushUint32() error {
 func (vm *VirtualMachine) OpPushUint64() error {
 	value, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.PushUint64.String()}
+	}
+	if err := vm.CheckStack(op.PushUint64); err != nil {
 		return err
 	}
 	vm.Push(value)
-	vm.idx += 3
+	vm.idx += op.PushUint64.Size()
 	return nil
 }
 
@@ -70,10 +82,13 @@ func (vm *VirtualMachine) OpPushUint64() error {
 func (vm *VirtualMachine) OpPushFloat32() error {
 	value, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.PushFloat32.String()}
+	}
+	if err := vm.CheckStack(op.PushFloat32); err != nil {
 		return err
 	}
 	vm.Push(float64(math.Float32frombits(value)))
-	vm.idx += 2
+	vm.idx += op.PushFloat32.Size()
 	return nil
 }
 
@@ -84,24 +99,30 @@ func (vm *VirtualMachine) OpPushFloat32() error {
 func (vm *VirtualMachine) OpPushFloat64() error {
 	value, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.PushFloat64.String()}
+	}
+	if err := vm.CheckStack(op.PushFloat64); err != nil {
 		return err
 	}
 	vm.Push(math.Float64frombits(value))
-	vm.idx += 3
+	vm.idx += op.PushFloat64.Size()
 	return nil
 }
 
 // OpPop implements the Pop opcode for the reference VM.
 //
 // This function will remove the topmost value of the stack. If popping the
-// value would reveal the FrameBase value on the stack, this function generates
-// an index out of bounds error.
+// value would pop past the FrameBase of the current frame, this function
+// generates a too few values error.
 func (vm *VirtualMachine) OpPop() error {
-	if len(vm.Stack)-1 == vm.FrameBase {
-		return vmerr.IndexOutOfBoundsError{OpCode: "Pop"}
+	if len(vm.Stack) <= vm.FrameBase {
+		return vmerr.TooFewValuesError{OpCode: op.Pop.String()}
 	}
-	_, err := vm.Pop("Pop")
-	return err
+	if _, err := vm.Pop(op.Pop); err != nil {
+		return err
+	}
+	vm.idx += op.Pop.Size()
+	return nil
 }
 
 // OpPopN implements the PopN opcode for the reference VM.
@@ -110,20 +131,20 @@ func (vm *VirtualMachine) OpPop() error {
 // u32 `N`, then pop that many values from the stack.
 //
 // If popping that many values from the stack would result in popping past the
-// FrameBase value, an index out of bounds error is generated.
+// FrameBase value, a too few values error is generated.
 func (vm *VirtualMachine) OpPopN() error {
 	n, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return err
+		return vmerr.MissingConstArgError{OpCode: op.PopN.String()}
 	}
 
 	last := len(vm.Stack) - int(n)
-	if last < 0 || last <= vm.FrameBase || last >= len(vm.Stack) {
-		return vmerr.IndexOutOfBoundsError{OpCode: "PopN"}
+	if last < vm.FrameBase || last >= len(vm.Stack) {
+		return vmerr.TooFewValuesError{OpCode: op.PopN.String()}
 	}
 
 	vm.Stack = vm.Stack[:last]
-	vm.idx += 2
+	vm.idx += op.PopN.Size()
 	return nil
 }
 
@@ -135,14 +156,17 @@ func (vm *VirtualMachine) OpPopN() error {
 func (vm *VirtualMachine) OpCopy() error {
 	offset, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return err
+		return vmerr.MissingConstArgError{OpCode: op.Copy.String()}
 	}
 	idx := vm.FrameBase + int(offset)
 	if idx < 0 || idx >= len(vm.Stack) {
-		return vmerr.IndexOutOfBoundsError{OpCode: "Copy"}
+		return vmerr.IndexOutOfBoundsError{OpCode: op.Copy.String()}
+	}
+	if err := vm.CheckStack(op.Copy); err != nil {
+		return err
 	}
 	vm.Push(vm.Stack[idx])
-	vm.idx += 2
+	vm.idx += op.Copy.Size()
 	return nil
 }
 
@@ -158,15 +182,15 @@ func (vm *VirtualMachine) OpCopy() error {
 func (vm *VirtualMachine) OpSwap() error {
 	offset, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return err
+		return vmerr.MissingConstArgError{OpCode: op.Swap.String()}
 	}
 	idx := vm.FrameBase + int(offset)
 	last := len(vm.Stack) - 1
 	if idx < 0 || idx >= len(vm.Stack) || last < 0 || last >= len(vm.Stack) {
-		return vmerr.IndexOutOfBoundsError{OpCode: "Swap"}
+		return vmerr.IndexOutOfBoundsError{OpCode: op.Swap.String()}
 	}
 	vm.Stack[idx], vm.Stack[last] = vm.Stack[last], vm.Stack[idx]
-	vm.idx += 2
+	vm.idx += op.Swap.Size()
 	return nil
 }
 
@@ -176,7 +200,7 @@ func (vm *VirtualMachine) OpSwap() error {
 // push it back to the stack. If the value is a uint64, this will the negative
 // bit pattern.
 func (vm *VirtualMachine) OpNegative() error {
-	top, err := vm.Pop("Negative")
+	top, err := vm.Pop(op.Negative)
 	if err != nil {
 		return err
 	}
@@ -189,84 +213,94 @@ func (vm *VirtualMachine) OpNegative() error {
 	case float64:
 		vm.Push(-v)
 	default:
-		return vmerr.InvalidTypeError{OpCode: "Negative"}
+		return vmerr.InvalidTypeError{OpCode: op.Negative.String()}
 	}
-	vm.idx++
+	vm.idx += op.Negative.Size()
 	return nil
 }
 
 // OpAddInt implements the AddInt opcode for the reference VM.
 func (vm *VirtualMachine) OpAddInt() error {
-	v1, err := vm.PopInt("AddInt")
+	v1, err := vm.PopInt(op.AddInt)
 	if err != nil {
 		return err
 	}
-	v2, err := vm.PopInt("AddInt")
+	v2, err := vm.PopInt(op.AddInt)
 	if err != nil {
 		return err
 	}
 	vm.Push(v1 + v2)
-	vm.idx++
+	vm.idx += op.AddInt.Size()
 	return nil
 }
 
 // OpSubInt implements the SubInt opcode for the reference VM.
 func (vm *VirtualMachine) OpSubInt() error {
-	v1, err := vm.PopInt("AddInt")
+	v1, err := vm.PopInt(op.SubInt)
 	if err != nil {
 		return err
 	}
-	v2, err := vm.PopInt("AddInt")
+	v2, err := vm.PopInt(op.SubInt)
 	if err != nil {
 		return err
 	}
 	vm.Push(v1 - v2)
-	vm.idx++
+	vm.idx += op.SubInt.Size()
 	return nil
 }
 
 // OpMulInt implements the MulInt opcode for the reference VM.
 func (vm *VirtualMachine) OpMulInt() error {
-	v1, err := vm.PopInt("AddInt")
+	v1, err := vm.PopInt(op.MulInt)
 	if err != nil {
 		return err
 	}
-	v2, err := vm.PopInt("AddInt")
+	v2, err := vm.PopInt(op.MulInt)
 	if err != nil {
 		return err
 	}
 	vm.Push(v1 * v2)
-	vm.idx++
+	vm.idx += op.MulInt.Size()
 	return nil
 }
 
 // OpDivInt implements the DivInt opcode for the reference VM.
+//
+// The topmost value of the stack is divided by the second value. A zero
+// divisor generates a division by zero error, and dividing the minimum int64
+// by -1 generates an integer overflow error.
 func (vm *VirtualMachine) OpDivInt() error {
-	v1, err := vm.PopInt("AddInt")
+	v1, err := vm.PopInt(op.DivInt)
 	if err != nil {
 		return err
 	}
-	v2, err := vm.PopInt("AddInt")
+	v2, err := vm.PopInt(op.DivInt)
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return vmerr.DivisionByZeroError{OpCode: op.DivInt.String()}
+	}
+	if v1 == math.MinInt64 && v2 == -1 {
+		return vmerr.IntegerOverflowError{OpCode: op.DivInt.String()}
+	}
 	vm.Push(v1 / v2)
-	vm.idx++
+	vm.idx += op.DivInt.Size()
 	return nil
 }
 
 // OpAddConstInt32 implements the AddConstI32 opcode for the reference VM.
 func (vm *VirtualMachine) OpAddConstInt32() error {
-	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
+	v1, err := op.ConstArgI32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "AddConstI32"}
+		return vmerr.MissingConstArgError{OpCode: op.AddConstInt32.String()}
 	}
-	v2, err := vm.PopInt("AddConstI32")
+	v2, err := vm.PopInt(op.AddConstInt32)
 	if err != nil {
 		return err
 	}
 	vm.Push(int64(v1) + v2)
-	vm.idx += 2
+	vm.idx += op.AddConstInt32.Size()
 	return nil
 }
 
@@ -274,14 +308,14 @@ func (vm *VirtualMachine) OpAddConstInt32() error {
 func (vm *VirtualMachine) OpAddConstInt64() error {
 	v1, err := op.ConstArgI64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "AddConstI64"}
+		return vmerr.MissingConstArgError{OpCode: op.AddConstInt64.String()}
 	}
-	v2, err := vm.PopInt("AddConstI64")
+	v2, err := vm.PopInt(op.AddConstInt64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v1 + v2)
-	vm.idx += 3
+	vm.idx += op.AddConstInt64.Size()
 	return nil
 }
 
@@ -289,14 +323,14 @@ func (vm *VirtualMachine) OpAddConstInt64() error {
 func (vm *VirtualMachine) OpAddConstUint32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "AddConstU32"}
+		return vmerr.MissingConstArgError{OpCode: op.AddConstUint32.String()}
 	}
-	v2, err := vm.PopUint("AddConstU32")
+	v2, err := vm.PopUint(op.AddConstUint32)
 	if err != nil {
 		return err
 	}
 	vm.Push(uint64(v1) + v2)
-	vm.idx += 2
+	vm.idx += op.AddConstUint32.Size()
 	return nil
 }
 
@@ -304,14 +338,14 @@ func (vm *VirtualMachine) OpAddConstUint32() error {
 func (vm *VirtualMachine) OpAddConstUint64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "AddConstU64"}
+		return vmerr.MissingConstArgError{OpCode: op.AddConstUint64.String()}
 	}
-	v2, err := vm.PopUint("AddConstU64")
+	v2, err := vm.PopUint(op.AddConstUint64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v1 + v2)
-	vm.idx += 3
+	vm.idx += op.AddConstUint64.Size()
 	return nil
 }
 
@@ -319,14 +353,14 @@ func (vm *VirtualMachine) OpAddConstUint64() error {
 func (vm *VirtualMachine) OpAddConstFloat32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "AddConstF32"}
+		return vmerr.MissingConstArgError{OpCode: op.AddConstFloat32.String()}
 	}
-	v2, err := vm.PopFloat("AddConstF32")
+	v2, err := vm.PopFloat(op.AddConstFloat32)
 	if err != nil {
 		return err
 	}
 	vm.Push(float64(math.Float32frombits(v1)) + v2)
-	vm.idx += 2
+	vm.idx += op.AddConstFloat32.Size()
 	return nil
 }
 
@@ -334,14 +368,14 @@ func (vm *VirtualMachine) OpAddConstFloat32() error {
 func (vm *VirtualMachine) OpAddConstFloat64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "AddConstF64"}
+		return vmerr.MissingConstArgError{OpCode: op.AddConstFloat64.String()}
 	}
-	v2, err := vm.PopFloat("AddConstF64")
+	v2, err := vm.PopFloat(op.AddConstFloat64)
 	if err != nil {
 		return err
 	}
 	vm.Push(math.Float64frombits(v1) + v2)
-	vm.idx += 3
+	vm.idx += op.AddConstFloat64.Size()
 	return nil
 }
 
@@ -349,14 +383,14 @@ func (vm *VirtualMachine) OpAddConstFloat64() error {
 func (vm *VirtualMachine) OpSubConstInt32() error {
 	v1, err := op.ConstArgI32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "SubConstI32"}
+		return vmerr.MissingConstArgError{OpCode: op.SubConstInt32.String()}
 	}
-	v2, err := vm.PopInt("SubConstI32")
+	v2, err := vm.PopInt(op.SubConstInt32)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 - int64(v1))
-	vm.idx += 2
+	vm.idx += op.SubConstInt32.Size()
 	return nil
 }
 
@@ -364,14 +398,14 @@ func (vm *VirtualMachine) OpSubConstInt32() error {
 func (vm *VirtualMachine) OpSubConstInt64() error {
 	v1, err := op.ConstArgI64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "SubConstI64"}
+		return vmerr.MissingConstArgError{OpCode: op.SubConstInt64.String()}
 	}
-	v2, err := vm.PopInt("SubConstI64")
+	v2, err := vm.PopInt(op.SubConstInt64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 - v1)
-	vm.idx += 3
+	vm.idx += op.SubConstInt64.Size()
 	return nil
 }
 
@@ -379,14 +413,14 @@ func (vm *VirtualMachine) OpSubConstInt64() error {
 func (vm *VirtualMachine) OpSubConstUint32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "SubConstU32"}
+		return vmerr.MissingConstArgError{OpCode: op.SubConstUint32.String()}
 	}
-	v2, err := vm.PopUint("SubConstU32")
+	v2, err := vm.PopUint(op.SubConstUint32)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 - uint64(v1))
-	vm.idx += 2
+	vm.idx += op.SubConstUint32.Size()
 	return nil
 }
 
@@ -394,14 +428,14 @@ func (vm *VirtualMachine) OpSubConstUint32() error {
 func (vm *VirtualMachine) OpSubConstUint64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "SubConstU64"}
+		return vmerr.MissingConstArgError{OpCode: op.SubConstUint64.String()}
 	}
-	v2, err := vm.PopUint("SubConstU64")
+	v2, err := vm.PopUint(op.SubConstUint64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 - v1)
-	vm.idx += 3
+	vm.idx += op.SubConstUint64.Size()
 	return nil
 }
 
@@ -409,14 +443,14 @@ func (vm *VirtualMachine) OpSubConstUint64() error {
 func (vm *VirtualMachine) OpSubConstFloat32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "SubConstF32"}
+		return vmerr.MissingConstArgError{OpCode: op.SubConstFloat32.String()}
 	}
-	v2, err := vm.PopFloat("SubConstF32")
+	v2, err := vm.PopFloat(op.SubConstFloat32)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 - float64(math.Float32frombits(v1)))
-	vm.idx += 2
+	vm.idx += op.SubConstFloat32.Size()
 	return nil
 }
 
@@ -424,14 +458,14 @@ func (vm *VirtualMachine) OpSubConstFloat32() error {
 func (vm *VirtualMachine) OpSubConstFloat64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "SubConstF64"}
+		return vmerr.MissingConstArgError{OpCode: op.SubConstFloat64.String()}
 	}
-	v2, err := vm.PopFloat("SubConstF64")
+	v2, err := vm.PopFloat(op.SubConstFloat64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 - math.Float64frombits(v1))
-	vm.idx += 3
+	vm.idx += op.SubConstFloat64.Size()
 	return nil
 }
 
@@ -439,14 +473,14 @@ func (vm *VirtualMachine) OpSubConstFloat64() error {
 func (vm *VirtualMachine) OpMulConstInt32() error {
 	v1, err := op.ConstArgI32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "MulConstI32"}
+		return vmerr.MissingConstArgError{OpCode: op.MulConstInt32.String()}
 	}
-	v2, err := vm.PopInt("MulConstI32")
+	v2, err := vm.PopInt(op.MulConstInt32)
 	if err != nil {
 		return err
 	}
 	vm.Push(int64(v1) * v2)
-	vm.idx += 2
+	vm.idx += op.MulConstInt32.Size()
 	return nil
 }
 
@@ -454,14 +488,14 @@ func (vm *VirtualMachine) OpMulConstInt32() error {
 func (vm *VirtualMachine) OpMulConstInt64() error {
 	v1, err := op.ConstArgI64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "MulConstI64"}
+		return vmerr.MissingConstArgError{OpCode: op.MulConstInt64.String()}
 	}
-	v2, err := vm.PopInt("MulConstI64")
+	v2, err := vm.PopInt(op.MulConstInt64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v1 * v2)
-	vm.idx += 3
+	vm.idx += op.MulConstInt64.Size()
 	return nil
 }
 
@@ -469,14 +503,14 @@ func (vm *VirtualMachine) OpMulConstInt64() error {
 func (vm *VirtualMachine) OpMulConstUint32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "MulConstU32"}
+		return vmerr.MissingConstArgError{OpCode: op.MulConstUint32.String()}
 	}
-	v2, err := vm.PopUint("MulConstU32")
+	v2, err := vm.PopUint(op.MulConstUint32)
 	if err != nil {
 		return err
 	}
 	vm.Push(uint64(v1) * v2)
-	vm.idx += 2
+	vm.idx += op.MulConstUint32.Size()
 	return nil
 }
 
@@ -484,14 +518,14 @@ func (vm *VirtualMachine) OpMulConstUint32() error {
 func (vm *VirtualMachine) OpMulConstUint64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "MulConstU64"}
+		return vmerr.MissingConstArgError{OpCode: op.MulConstUint64.String()}
 	}
-	v2, err := vm.PopUint("MulConstU64")
+	v2, err := vm.PopUint(op.MulConstUint64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v1 * v2)
-	vm.idx += 3
+	vm.idx += op.MulConstUint64.Size()
 	return nil
 }
 
@@ -499,14 +533,14 @@ func (vm *VirtualMachine) OpMulConstUint64() error {
 func (vm *VirtualMachine) OpMulConstFloat32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "MulConstF32"}
+		return vmerr.MissingConstArgError{OpCode: op.MulConstFloat32.String()}
 	}
-	v2, err := vm.PopFloat("MulConstF32")
+	v2, err := vm.PopFloat(op.MulConstFloat32)
 	if err != nil {
 		return err
 	}
 	vm.Push(float64(math.Float32frombits(v1)) * v2)
-	vm.idx += 2
+	vm.idx += op.MulConstFloat32.Size()
 	return nil
 }
 
@@ -514,14 +548,14 @@ func (vm *VirtualMachine) OpMulConstFloat32() error {
 func (vm *VirtualMachine) OpMulConstFloat64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "MulConstF64"}
+		return vmerr.MissingConstArgError{OpCode: op.MulConstFloat64.String()}
 	}
-	v2, err := vm.PopFloat("MulConstF64")
+	v2, err := vm.PopFloat(op.MulConstFloat64)
 	if err != nil {
 		return err
 	}
 	vm.Push(math.Float64frombits(v1) * v2)
-	vm.idx += 3
+	vm.idx += op.MulConstFloat64.Size()
 	return nil
 }
 
@@ -529,14 +563,20 @@ func (vm *VirtualMachine) OpMulConstFloat64() error {
 func (vm *VirtualMachine) OpDivConstInt32() error {
 	v1, err := op.ConstArgI32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "DivConstI32"}
+		return vmerr.MissingConstArgError{OpCode: op.DivConstInt32.String()}
 	}
-	v2, err := vm.PopInt("DivConstI32")
+	v2, err := vm.PopInt(op.DivConstInt32)
 	if err != nil {
 		return err
 	}
+	if v1 == 0 {
+		return vmerr.DivisionByZeroError{OpCode: op.DivConstInt32.String()}
+	}
+	if v2 == math.MinInt64 && v1 == -1 {
+		return vmerr.IntegerOverflowError{OpCode: op.DivConstInt32.String()}
+	}
 	vm.Push(v2 / int64(v1))
-	vm.idx += 2
+	vm.idx += op.DivConstInt32.Size()
 	return nil
 }
 
@@ -544,14 +584,20 @@ func (vm *VirtualMachine) OpDivConstInt32() error {
 func (vm *VirtualMachine) OpDivConstInt64() error {
 	v1, err := op.ConstArgI64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "DivConstI64"}
+		return vmerr.MissingConstArgError{OpCode: op.DivConstInt64.String()}
 	}
-	v2, err := vm.PopInt("DivConstI64")
+	v2, err := vm.PopInt(op.DivConstInt64)
 	if err != nil {
 		return err
 	}
+	if v1 == 0 {
+		return vmerr.DivisionByZeroError{OpCode: op.DivConstInt64.String()}
+	}
+	if v2 == math.MinInt64 && v1 == -1 {
+		return vmerr.IntegerOverflowError{OpCode: op.DivConstInt64.String()}
+	}
 	vm.Push(v2 / v1)
-	vm.idx += 3
+	vm.idx += op.DivConstInt64.Size()
 	return nil
 }
 
@@ -559,14 +605,17 @@ func (vm *VirtualMachine) OpDivConstInt64() error {
 func (vm *VirtualMachine) OpDivConstUint32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "DivConstU32"}
+		return vmerr.MissingConstArgError{OpCode: op.DivConstUint32.String()}
 	}
-	v2, err := vm.PopUint("DivConstU32")
+	v2, err := vm.PopUint(op.DivConstUint32)
 	if err != nil {
 		return err
 	}
+	if v1 == 0 {
+		return vmerr.DivisionByZeroError{OpCode: op.DivConstUint32.String()}
+	}
 	vm.Push(v2 / uint64(v1))
-	vm.idx += 2
+	vm.idx += op.DivConstUint32.Size()
 	return nil
 }
 
@@ -574,14 +623,17 @@ func (vm *VirtualMachine) OpDivConstUint32() error {
 func (vm *VirtualMachine) OpDivConstUint64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "DivConstU64"}
+		return vmerr.MissingConstArgError{OpCode: op.DivConstUint64.String()}
 	}
-	v2, err := vm.PopUint("DivConstU64")
+	v2, err := vm.PopUint(op.DivConstUint64)
 	if err != nil {
 		return err
 	}
+	if v1 == 0 {
+		return vmerr.DivisionByZeroError{OpCode: op.DivConstUint64.String()}
+	}
 	vm.Push(v2 / v1)
-	vm.idx += 3
+	vm.idx += op.DivConstUint64.Size()
 	return nil
 }
 
@@ -589,14 +641,14 @@ func (vm *VirtualMachine) OpDivConstUint64() error {
 func (vm *VirtualMachine) OpDivConstFloat32() error {
 	v1, err := op.ConstArgU32(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "DivConstF32"}
+		return vmerr.MissingConstArgError{OpCode: op.DivConstFloat32.String()}
 	}
-	v2, err := vm.PopFloat("DivConstF32")
+	v2, err := vm.PopFloat(op.DivConstFloat32)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 / float64(math.Float32frombits(v1)))
-	vm.idx += 2
+	vm.idx += op.DivConstFloat32.Size()
 	return nil
 }
 
@@ -604,20 +656,20 @@ func (vm *VirtualMachine) OpDivConstFloat32() error {
 func (vm *VirtualMachine) OpDivConstFloat64() error {
 	v1, err := op.ConstArgU64(vm.code, vm.idx+1)
 	if err != nil {
-		return vmerr.MissingConstArgError{OpCode: "DivConstF64"}
+		return vmerr.MissingConstArgError{OpCode: op.DivConstFloat64.String()}
 	}
-	v2, err := vm.PopFloat("DivConstF64")
+	v2, err := vm.PopFloat(op.DivConstFloat64)
 	if err != nil {
 		return err
 	}
 	vm.Push(v2 / math.Float64frombits(v1))
-	vm.idx += 3
+	vm.idx += op.DivConstFloat64.Size()
 	return nil
 }
 
 // OpIncrement implements the Increment opcode for the reference VM.
 func (vm *VirtualMachine) OpIncrement() error {
-	top, err := vm.Pop("Increment")
+	top, err := vm.Pop(op.Increment)
 	if err != nil {
 		return err
 	}
@@ -629,15 +681,15 @@ func (vm *VirtualMachine) OpIncrement() error {
 	case float64:
 		vm.Push(v + 1)
 	default:
-		return vmerr.InvalidTypeError{OpCode: "Increment"}
+		return vmerr.InvalidTypeError{OpCode: op.Increment.String()}
 	}
-	vm.idx++
+	vm.idx += op.Increment.Size()
 	return nil
 }
 
 // OpDecrement implements the Decrement opcode for the reference VM.
 func (vm *VirtualMachine) OpDecrement() error {
-	top, err := vm.Pop("Decrement")
+	top, err := vm.Pop(op.Decrement)
 	if err != nil {
 		return err
 	}
@@ -649,8 +701,480 @@ func (vm *VirtualMachine) OpDecrement() error {
 	case float64:
 		vm.Push(v - 1)
 	default:
-		return vmerr.InvalidTypeError{OpCode: "Decrement"}
+		return vmerr.InvalidTypeError{OpCode: op.Decrement.String()}
+	}
+	vm.idx += op.Decrement.Size()
+	return nil
+}
+
+// OpCall implements the Call opcode for the reference VM.
+//
+// This function takes the next value in the bytecode as an int32 `OFFSET`
+// relative to the Call opcode, and the value after that as a uint32 `ARGC`.
+// A new Frame recording the return address and current FrameBase is pushed,
+// the FrameBase is moved to the first of the `ARGC` topmost values on the
+// stack, and execution continues at the target of `OFFSET`.
+func (vm *VirtualMachine) OpCall() error {
+	offset, err := op.ConstArgI32(vm.code, vm.idx+1)
+	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.Call.String()}
+	}
+	argc, err := op.ConstArgU32(vm.code, vm.idx+2)
+	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.Call.String()}
+	}
+
+	base := len(vm.Stack) - int(argc)
+	if base < vm.FrameBase {
+		return vmerr.TooFewValuesError{OpCode: op.Call.String()}
+	}
+	if vm.MaxFrames != 0 && len(vm.Frames) >= vm.MaxFrames {
+		return vmerr.CallDepthExceededError{OpCode: op.Call.String()}
+	}
+	vm.Frames = append(vm.Frames, Frame{
+		ReturnAddress: vm.idx + op.Call.Size(),
+		FrameBase:     vm.FrameBase,
+		Entry:         vm.idx + int(offset),
+	})
+	vm.FrameBase = base
+	if vm.Tracer != nil {
+		vm.Tracer.OnCall(vm.idx, vm.idx+int(offset), len(vm.Frames))
+	}
+	vm.idx += int(offset)
+	return nil
+}
+
+// OpReturn implements the Return opcode for the reference VM.
+//
+// This function takes the next value in the bytecode as a uint32 `N`. The `N`
+// topmost values of the stack are kept as the return values, and everything
+// else in the current frame is discarded. The caller's FrameBase is restored
+// and execution continues after the Call opcode.
+//
+// Returning when there is no active frame ends the program, as if the top
+// level of the program were a function itself.
+func (vm *VirtualMachine) OpReturn() error {
+	n, err := op.ConstArgU32(vm.code, vm.idx+1)
+	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.Return.String()}
+	}
+
+	first := len(vm.Stack) - int(n)
+	if first < vm.FrameBase {
+		return vmerr.TooFewValuesError{OpCode: op.Return.String()}
+	}
+	results := vm.Stack[first:]
+	vm.Stack = append(vm.Stack[:vm.FrameBase], results...)
+
+	if len(vm.Frames) == 0 {
+		vm.idx += op.Return.Size()
+		return ErrHalt
+	}
+	frame := vm.Frames[len(vm.Frames)-1]
+	vm.Frames = vm.Frames[:len(vm.Frames)-1]
+	vm.FrameBase = frame.FrameBase
+	if vm.Tracer != nil {
+		vm.Tracer.OnReturn(vm.idx, frame.ReturnAddress, len(vm.Frames))
+	}
+	vm.idx = frame.ReturnAddress
+	return nil
+}
+
+// OpNativeCall implements the NativeCall opcode for the reference VM.
+//
+// This function takes the next value in the bytecode as a uint32 `INDEX` into
+// the Natives of the VM and the value after that as a uint32 `ARGC`. The
+// `ARGC` topmost values of the stack are popped and passed to the native
+// function in the order they were pushed, and the values it returns are
+// pushed in order. The results are checked against MaxStack before any are
+// pushed, so a native returning too many values leaves the stack as it was.
+func (vm *VirtualMachine) OpNativeCall() error {
+	index, err := op.ConstArgU32(vm.code, vm.idx+1)
+	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.NativeCall.String()}
+	}
+	argc, err := op.ConstArgU32(vm.code, vm.idx+2)
+	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: op.NativeCall.String()}
+	}
+	if uint64(index) >= uint64(len(vm.Natives)) {
+		return vmerr.IndexOutOfBoundsError{OpCode: op.NativeCall.String()}
+	}
+	first := len(vm.Stack) - int(argc)
+	if first < vm.FrameBase {
+		return vmerr.TooFewValuesError{OpCode: op.NativeCall.String()}
+	}
+
+	native := vm.Natives[index]
+	args := append([]interface{}{}, vm.Stack[first:]...)
+	results, err := native.Func(args)
+	if err != nil {
+		return vmerr.NativeError{OpCode: op.NativeCall.String(), Name: native.Name, Err: err}
+	}
+	for _, result := range results {
+		switch result.(type) {
+		case int64, uint64, float64:
+		default:
+			return vmerr.InvalidTypeError{OpCode: op.NativeCall.String()}
+		}
+	}
+	if vm.MaxStack != 0 && first+len(results) > vm.MaxStack {
+		return vmerr.StackOverflowError{OpCode: op.NativeCall.String()}
+	}
+	vm.Stack = append(vm.Stack[:first], results...)
+	vm.idx += op.NativeCall.Size()
+	return nil
+}
+
+// branch implements the common logic of the jump opcodes.
+//
+// The next value in the bytecode is taken as an int32 `OFFSET` relative to the
+// jump opcode. If take is true, execution continues at the target of `OFFSET`,
+// otherwise it continues with the next opcode.
+func (vm *VirtualMachine) branch(opcode op.Op, take func() (bool, error)) error {
+	offset, err := op.ConstArgI32(vm.code, vm.idx+1)
+	if err != nil {
+		return vmerr.MissingConstArgError{OpCode: opcode.String()}
+	}
+	ok, err := take()
+	if err != nil {
+		return err
+	}
+	if ok {
+		vm.idx += int(offset)
+	} else {
+		vm.idx += opcode.Size()
+	}
+	return nil
+}
+
+// popZero pops the topmost value from the stack and checks if it is the zero
+// value of its own type.
+func (vm *VirtualMachine) popZero(opcode op.Op) (bool, error) {
+	top, err := vm.Pop(opcode)
+	if err != nil {
+		return false, err
+	}
+	switch v := top.(type) {
+	case int64:
+		return v == 0, nil
+	case uint64:
+		return v == 0, nil
+	case float64:
+		return v == 0, nil
+	}
+	return false, vmerr.InvalidTypeError{OpCode: opcode.String()}
+}
+
+// OpJump implements the Jump opcode for the reference VM.
+//
+// This function takes the next value in the bytecode as an int32 `OFFSET`
+// relative to the Jump opcode and continues execution at that target.
+func (vm *VirtualMachine) OpJump() error {
+	return vm.branch(op.Jump, func() (bool, error) {
+		return true, nil
+	})
+}
+
+// OpJumpIfZero implements the JumpIfZero opcode for the reference VM.
+//
+// This function pops the topmost value of the stack and jumps if it is the
+// zero value of its type.
+func (vm *VirtualMachine) OpJumpIfZero() error {
+	return vm.branch(op.JumpIfZero, func() (bool, error) {
+		return vm.popZero(op.JumpIfZero)
+	})
+}
+
+// OpJumpIfNotZero implements the JumpIfNotZero opcode for the reference VM.
+//
+// This function pops the topmost value of the stack and jumps if it is not
+// the zero value of its type.
+func (vm *VirtualMachine) OpJumpIfNotZero() error {
+	return vm.branch(op.JumpIfNotZero, func() (bool, error) {
+		zero, err := vm.popZero(op.JumpIfNotZero)
+		return !zero, err
+	})
+}
+
+// OpJumpIfZeroInt implements the JumpIfZeroInt opcode for the reference VM.
+//
+// This function pops the topmost value of the stack as an int and jumps if it
+// is zero.
+func (vm *VirtualMachine) OpJumpIfZeroInt() error {
+	return vm.branch(op.JumpIfZeroInt, func() (bool, error) {
+		v, err := vm.PopInt(op.JumpIfZeroInt)
+		return v == 0, err
+	})
+}
+
+// OpJumpIfZeroUint implements the JumpIfZeroUint opcode for the reference VM.
+//
+// This function pops the topmost value of the stack as a uint and jumps if it
+// is zero.
+func (vm *VirtualMachine) OpJumpIfZeroUint() error {
+	return vm.branch(op.JumpIfZeroUint, func() (bool, error) {
+		v, err := vm.PopUint(op.JumpIfZeroUint)
+		return v == 0, err
+	})
+}
+
+// OpJumpIfZeroFloat implements the JumpIfZeroFloat opcode for the reference VM.
+//
+// This function pops the topmost value of the stack as a float and jumps if it
+// is zero.
+func (vm *VirtualMachine) OpJumpIfZeroFloat() error {
+	return vm.branch(op.JumpIfZeroFloat, func() (bool, error) {
+		v, err := vm.PopFloat(op.JumpIfZeroFloat)
+		return v == 0, err
+	})
+}
+
+// OpJumpIfNotZeroInt implements the JumpIfNotZeroInt opcode for the reference VM.
+//
+// This function pops the topmost value of the stack as an int and jumps if it
+// is not zero.
+func (vm *VirtualMachine) OpJumpIfNotZeroInt() error {
+	return vm.branch(op.JumpIfNotZeroInt, func() (bool, error) {
+		v, err := vm.PopInt(op.JumpIfNotZeroInt)
+		return v != 0, err
+	})
+}
+
+// OpJumpIfNotZeroUint implements the JumpIfNotZeroUint opcode for the reference VM.
+//
+// This function pops the topmost value of the stack as a uint and jumps if it
+// is not zero.
+func (vm *VirtualMachine) OpJumpIfNotZeroUint() error {
+	return vm.branch(op.JumpIfNotZeroUint, func() (bool, error) {
+		v, err := vm.PopUint(op.JumpIfNotZeroUint)
+		return v != 0, err
+	})
+}
+
+// OpJumpIfNotZeroFloat implements the JumpIfNotZeroFloat opcode for the reference VM.
+//
+// This function pops the topmost value of the stack as a float and jumps if it
+// is not zero.
+func (vm *VirtualMachine) OpJumpIfNotZeroFloat() error {
+	return vm.branch(op.JumpIfNotZeroFloat, func() (bool, error) {
+		v, err := vm.PopFloat(op.JumpIfNotZeroFloat)
+		return v != 0, err
+	})
+}
+
+// popCompare pops the topmost two values from the stack and compares them,
+// returning -1, 0 or 1 as the second value is less than, equal to or greater
+// than the topmost value.
+//
+// Comparisons are done by the mathematical value of the operands; an int64
+// and a uint64 are never converted to each other's type. If either value is a
+// float64, both values are converted to float64 and compared as floats.
+//
+// The second return value is false if the values are unordered, which is the
+// case when either is a NaN. The comparison result then orders NaN values
+// before any other value and equal to each other, which is the order used by
+// the Cmp opcode. The other comparison opcodes treat a NaN as unordered with
+// every value; Ne pushes 1 if either value is a NaN, and the rest push 0.
+func (vm *VirtualMachine) popCompare(opcode op.Op) (int, bool, error) {
+	b, err := vm.Pop(opcode)
+	if err != nil {
+		return 0, false, err
+	}
+	a, err := vm.Pop(opcode)
+	if err != nil {
+		return 0, false, err
+	}
+	if vm.Strict && !sameType(a, b) {
+		return 0, false, vmerr.InvalidTypeError{OpCode: opcode.String()}
+	}
+
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	if aFloat || bFloat {
+		af, aok := toFloat(a)
+		bf, bok := toFloat(b)
+		if !aok || !bok {
+			return 0, false, vmerr.InvalidTypeError{OpCode: opcode.String()}
+		}
+		switch {
+		case math.IsNaN(af) && math.IsNaN(bf):
+			return 0, false, nil
+		case math.IsNaN(af):
+			return -1, false, nil
+		case math.IsNaN(bf):
+			return 1, false, nil
+		case af < bf:
+			return -1, true, nil
+		case af > bf:
+			return 1, true, nil
+		}
+		return 0, true, nil
+	}
+
+	switch av := a.(type) {
+	case int64:
+		switch bv := b.(type) {
+		case int64:
+			return compareInts(av, bv), true, nil
+		case uint64:
+			if av < 0 {
+				return -1, true, nil
+			}
+			return compareUints(uint64(av), bv), true, nil
+		}
+	case uint64:
+		switch bv := b.(type) {
+		case int64:
+			if bv < 0 {
+				return 1, true, nil
+			}
+			return compareUints(av, uint64(bv)), true, nil
+		case uint64:
+			return compareUints(av, bv), true, nil
+		}
+	}
+	return 0, false, vmerr.InvalidTypeError{OpCode: opcode.String()}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// pushBool pushes 1 to the stack if v is true, or 0 if it is false.
+func (vm *VirtualMachine) pushBool(v bool) {
+	if v {
+		vm.Push(int64(1))
+	} else {
+		vm.Push(int64(0))
+	}
+}
+
+// OpEq implements the Eq opcode for the reference VM.
+//
+// This function pops the topmost two values of the stack and pushes 1 if the
+// second value is equal to the topmost value, otherwise it pushes 0.
+func (vm *VirtualMachine) OpEq() error {
+	c, ordered, err := vm.popCompare(op.Eq)
+	if err != nil {
+		return err
+	}
+	vm.pushBool(ordered && c == 0)
+	vm.idx += op.Eq.Size()
+	return nil
+}
+
+// OpNe implements the Ne opcode for the reference VM.
+//
+// This function pops the topmost two values of the stack and pushes 1 if the
+// second value is not equal to the topmost value, otherwise it pushes 0.
+func (vm *VirtualMachine) OpNe() error {
+	c, ordered, err := vm.popCompare(op.Ne)
+	if err != nil {
+		return err
+	}
+	vm.pushBool(!ordered || c != 0)
+	vm.idx += op.Ne.Size()
+	return nil
+}
+
+// OpLt implements the Lt opcode for the reference VM.
+//
+// This function pops the topmost two values of the stack and pushes 1 if the
+// second value is less than the topmost value, otherwise it pushes 0.
+func (vm *VirtualMachine) OpLt() error {
+	c, ordered, err := vm.popCompare(op.Lt)
+	if err != nil {
+		return err
+	}
+	vm.pushBool(ordered && c < 0)
+	vm.idx += op.Lt.Size()
+	return nil
+}
+
+// OpLe implements the Le opcode for the reference VM.
+//
+// This function pops the topmost two values of the stack and pushes 1 if the
+// second value is less than or equal to the topmost value, otherwise
+// it pushes 0.
+func (vm *VirtualMachine) OpLe() error {
+	c, ordered, err := vm.popCompare(op.Le)
+	if err != nil {
+		return err
+	}
+	vm.pushBool(ordered && c <= 0)
+	vm.idx += op.Le.Size()
+	return nil
+}
+
+// OpGt implements the Gt opcode for the reference VM.
+//
+// This function pops the topmost two values of the stack and pushes 1 if the
+// second value is greater than the topmost value, otherwise it pushes 0.
+func (vm *VirtualMachine) OpGt() error {
+	c, ordered, err := vm.popCompare(op.Gt)
+	if err != nil {
+		return err
+	}
+	vm.pushBool(ordered && c > 0)
+	vm.idx += op.Gt.Size()
+	return nil
+}
+
+// OpGe implements the Ge opcode for the reference VM.
+//
+// This function pops the topmost two values of the stack and pushes 1 if the
+// second value is greater than or equal to the topmost value, otherwise it
+// pushes 0.
+func (vm *VirtualMachine) OpGe() error {
+	c, ordered, err := vm.popCompare(op.Ge)
+	if err != nil {
+		return err
+	}
+	vm.pushBool(ordered && c >= 0)
+	vm.idx += op.Ge.Size()
+	return nil
+}
+
+// OpCmp implements the Cmp opcode for the reference VM.
+//
+// This function pops the topmost two values of the stack and pushes -1, 0 or 1
+// as the second value is less than, equal to or greater than the topmost
+// value. A NaN is ordered before any other value and equal to another NaN.
+func (vm *VirtualMachine) OpCmp() error {
+	c, _, err := vm.popCompare(op.Cmp)
+	if err != nil {
+		return err
 	}
-	vm.idx++
+	vm.Push(int64(c))
+	vm.idx += op.Cmp.Size()
 	return nil
 }
@@ -1,8 +1,11 @@
 package reference
 
 import (
+	"reflect"
+
 	"github.com/tvarney/gotvm/op"
 	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
 )
 
 // VirtualMachine is a reference implementation of the virtual machine in the
@@ -14,29 +17,72 @@ import (
 //
 // This allows the machine to be much simpler to test, and can be used to
 // inspect the execution of a program much closer, at the expense of speed.
+//
+// Gas, MaxStack and MaxFrames are enforced the same way as in the main
+// package; a limit of zero means execution is not limited. The Tracer,
+// Natives, Strict and Debug fields mirror the vmopt.Config the VM was created
+// with.
 type VirtualMachine struct {
 	Stack     []interface{}
 	FrameBase int
+	Frames    []Frame
+	GasLimit  uint64
+	GasUsed   uint64
+	MaxStack  int
+	MaxFrames int
+	Tracer    vmopt.Tracer
+	Natives   []vmopt.Native
+	Strict    bool
+	Debug     *op.DebugInfo
 
 	code op.ByteCode
 	idx  int
 }
 
-// New returns a new VirtualMachine instance with a pre-allocated stack of 1024
-// items.
-func New() *VirtualMachine {
-	return NewWithSize(1024)
+// ContextCheckInterval is the number of opcodes ExecuteContext runs between
+// checks of its context for cancellation.
+const ContextCheckInterval = 1024
+
+// Frame is the record of an active function call.
+//
+// A frame is pushed by the Call opcode and popped by the Return opcode; it
+// holds the state of the caller which must be restored on return.
+type Frame struct {
+	ReturnAddress int
+	FrameBase     int
+	Entry         int // The offset of the function which was called
 }
 
-// NewWithSize returns a new VirtualMachine instance with a pre-allocated stack
-// of the given size.
-func NewWithSize(size uint32) *VirtualMachine {
+// Option configures a VirtualMachine. The options are defined in the vmopt
+// package and are shared with the VM in the main package.
+type Option = vmopt.Option
+
+// New returns a new VirtualMachine instance configured by the given options.
+// Without options the stack is pre-allocated to vmopt.DefaultStackSize items
+// and no limits are set.
+func New(opts ...Option) *VirtualMachine {
+	config := vmopt.New(opts...)
 	return &VirtualMachine{
-		Stack:     make([]interface{}, 0, int(size)),
+		Stack:     make([]interface{}, 0, config.StackSize),
 		FrameBase: 0,
+		GasLimit:  config.GasLimit,
+		MaxStack:  config.MaxStack,
+		MaxFrames: config.MaxFrames,
+		Tracer:    config.Tracer,
+		Natives:   config.Natives,
+		Strict:    config.Strict,
+		Debug:     config.Debug,
 	}
 }
 
+// NewWithSize returns a new VirtualMachine instance with a pre-allocated stack
+// of the given size.
+//
+// Deprecated: Use New(vmopt.WithStackSize(size)) instead.
+func NewWithSize(size uint32) *VirtualMachine {
+	return New(vmopt.WithStackSize(int(size)))
+}
+
 // Push adds the given value to the stack.
 //
 // The value given _must_ be one of a int64, uint64, or float64. This is not
@@ -44,26 +90,119 @@ func NewWithSize(size uint32) *VirtualMachine {
 // when the value is popped from the stack.
 //
 // TODO: Support string, list, map, and objects.
+//
+// Push does not check MaxStack; opcodes which grow the stack check it via
+// (*VirtualMachine).CheckStack first.
 func (vm *VirtualMachine) Push(v interface{}) {
 	vm.Stack = append(vm.Stack, v)
 }
 
+// CheckStack checks that the stack has room for another value, returning a
+// StackOverflowError for the given opcode if pushing would exceed MaxStack.
+func (vm *VirtualMachine) CheckStack(opcode op.Op) error {
+	if vm.MaxStack != 0 && len(vm.Stack) >= vm.MaxStack {
+		return vmerr.StackOverflowError{OpCode: opcode.String()}
+	}
+	return nil
+}
+
+// stackView implements vmopt.StackView for the stack of a VirtualMachine.
+type stackView VirtualMachine
+
+func (s *stackView) Len() int {
+	return len(s.Stack)
+}
+
+func (s *stackView) At(idx int) interface{} {
+	return s.Stack[idx]
+}
+
+// halt notifies the Tracer that the program finished.
+func (vm *VirtualMachine) halt() {
+	if vm.Tracer != nil {
+		vm.Tracer.OnHalt((*stackView)(vm))
+	}
+}
+
+// fail notifies the Tracer of the error which stopped the program, and
+// returns the error.
+func (vm *VirtualMachine) fail(err error) error {
+	if vm.Tracer != nil {
+		vm.Tracer.OnError(err)
+	}
+	return err
+}
+
+// top returns a copy of the values at the top of the stack as it was when it
+// held depth values, for a RuntimeError.
+//
+// An opcode may pop values before it fails, but never pushes after that, so
+// the values it popped are still in the array past the end of the stack.
+func (vm *VirtualMachine) top(depth int) []interface{} {
+	top := vm.Stack[max(0, depth-vmerr.StackSnapshotSize):depth]
+	return append([]interface{}{}, top...)
+}
+
+// stackTrace returns the active functions, innermost first.
+func (vm *VirtualMachine) stackTrace() []vmerr.Frame {
+	trace := make([]vmerr.Frame, 0, len(vm.Frames)+1)
+	base := vm.FrameBase
+	for idx := len(vm.Frames) - 1; idx >= 0; idx-- {
+		frame := vm.Frames[idx]
+		trace = append(trace, vmerr.Frame{
+			Function:  vm.Debug.Symbol(frame.Entry),
+			Entry:     frame.Entry,
+			CallSite:  frame.ReturnAddress - op.Call.Size(),
+			FrameBase: base,
+		})
+		base = frame.FrameBase
+	}
+	return append(trace, vmerr.Frame{
+		Function:  vm.Debug.Symbol(0),
+		Entry:     0,
+		CallSite:  -1,
+		FrameBase: base,
+	})
+}
+
+// runtimeError wraps an error raised by the instruction at the given offset
+// with the location and the top of the stack before the instruction ran.
+func (vm *VirtualMachine) runtimeError(offset int, top []interface{}, err error) error {
+	return vmerr.RuntimeError{
+		Err:    err,
+		Offset: offset,
+		OpCode: vm.code[offset].String(),
+		Line:   vm.Debug.Line(offset),
+		Stack:  top,
+		Frames: vm.stackTrace(),
+	}
+}
+
+// sameType checks if two values on the stack have the same type.
+func sameType(a, b interface{}) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b)
+}
+
 // Pop removes the topmost value from the stack and returns it.
-func (vm *VirtualMachine) Pop(opcode string) (interface{}, error) {
+func (vm *VirtualMachine) Pop(opcode op.Op) (interface{}, error) {
 	if len(vm.Stack) <= 0 {
-		return nil, vmerr.TooFewValuesError{OpCode: opcode}
+		return nil, vmerr.TooFewValuesError{OpCode: opcode.String()}
 	}
 	v := vm.Stack[len(vm.Stack)-1]
 	vm.Stack = vm.Stack[:len(vm.Stack)-1]
 	return v, nil
 }
 
-// PopInt pops the topmost value from the stack and coerces it to an int.
-func (vm *VirtualMachine) PopInt(opcode string) (int64, error) {
+// PopInt pops the topmost value from the stack and coerces it to an int. In
+// strict mode the value must already be an int.
+func (vm *VirtualMachine) PopInt(opcode op.Op) (int64, error) {
 	ival, err := vm.Pop(opcode)
 	if err != nil {
 		return 0, err
 	}
+	if _, ok := ival.(int64); vm.Strict && !ok {
+		return 0, vmerr.InvalidTypeError{OpCode: opcode.String()}
+	}
 	switch v := ival.(type) {
 	case int64:
 		return v, nil
@@ -72,16 +211,19 @@ func (vm *VirtualMachine) PopInt(opcode string) (int64, error) {
 	case float64:
 		return int64(v), nil
 	}
-	return 0, vmerr.InvalidTypeError{OpCode: opcode}
+	return 0, vmerr.InvalidTypeError{OpCode: opcode.String()}
 }
 
 // PopUint pops the topmost value from the stack and coerces it to an unsigned
-// int.
-func (vm *VirtualMachine) PopUint(opcode string) (uint64, error) {
+// int. In strict mode the value must already be an unsigned int.
+func (vm *VirtualMachine) PopUint(opcode op.Op) (uint64, error) {
 	ival, err := vm.Pop(opcode)
 	if err != nil {
 		return 0, err
 	}
+	if _, ok := ival.(uint64); vm.Strict && !ok {
+		return 0, vmerr.InvalidTypeError{OpCode: opcode.String()}
+	}
 	switch v := ival.(type) {
 	case int64:
 		return uint64(v), nil
@@ -90,15 +232,19 @@ func (vm *VirtualMachine) PopUint(opcode string) (uint64, error) {
 	case float64:
 		return uint64(v), nil
 	}
-	return 0, vmerr.InvalidTypeError{OpCode: opcode}
+	return 0, vmerr.InvalidTypeError{OpCode: opcode.String()}
 }
 
-// PopFloat pops the topmost value from the stack and coerces it to a float.
-func (vm *VirtualMachine) PopFloat(opcode string) (float64, error) {
+// PopFloat pops the topmost value from the stack and coerces it to a float. In
+// strict mode the value must already be a float.
+func (vm *VirtualMachine) PopFloat(opcode op.Op) (float64, error) {
 	ival, err := vm.Pop(opcode)
 	if err != nil {
 		return 0, err
 	}
+	if _, ok := ival.(float64); vm.Strict && !ok {
+		return 0, vmerr.InvalidTypeError{OpCode: opcode.String()}
+	}
 	switch v := ival.(type) {
 	case int64:
 		return float64(v), nil
@@ -107,5 +253,5 @@ func (vm *VirtualMachine) PopFloat(opcode string) (float64, error) {
 	case float64:
 		return v, nil
 	}
-	return 0, vmerr.InvalidTypeError{OpCode: opcode}
+	return 0, vmerr.InvalidTypeError{OpCode: opcode.String()}
 }
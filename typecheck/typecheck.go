@@ -0,0 +1,470 @@
+// Package typecheck infers the type of every value on the stack of a program
+// without running it.
+//
+// Both VMs only find an operand of the wrong type when the instruction runs;
+// a VM in strict mode fails with a vmerr.InvalidTypeError, and any other VM
+// converts the value. Infer finds the instructions which are given a value of
+// the wrong type whenever they run, so that the mistake can be reported when
+// the program is built.
+//
+// Types are tracked through branches and function calls. Where paths with
+// different types meet, or where a value comes from a native function, the
+// type is TypeUnknown; an instruction is only reported if the types are known
+// to be wrong.
+package typecheck
+
+import (
+	"fmt"
+
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/verify"
+	"github.com/tvarney/gotvm/vmerr"
+)
+
+// Type is the type of a value on the stack.
+type Type uint8
+
+const (
+	TypeUnknown Type = iota // The value may have any type
+	TypeInt                 // An int64
+	TypeUint                // A uint64
+	TypeFloat               // A float64
+)
+
+var typeNames = [...]string{
+	TypeUnknown: "unknown",
+	TypeInt:     "int",
+	TypeUint:    "uint",
+	TypeFloat:   "float",
+}
+
+// String returns the name of the type.
+func (t Type) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// merge returns the type of a value which has either type.
+func (t Type) merge(other Type) Type {
+	if t == other {
+		return t
+	}
+	return TypeUnknown
+}
+
+// Annotation is the types of the values an instruction takes from and adds
+// to the stack, each deepest first.
+type Annotation struct {
+	Pops   []Type
+	Pushes []Type
+}
+
+// Mismatch is an instruction which is given a value of the wrong type.
+//
+// A Mismatch unwraps to vmerr.ErrInvalidType, which a VM in strict mode fails
+// with when it runs the instruction.
+type Mismatch struct {
+	Offset int
+	OpCode string
+	Want   Type // The type the instruction takes
+	Got    Type // The type it is given
+}
+
+func (m Mismatch) Unwrap() error {
+	return vmerr.ErrInvalidType
+}
+
+func (m Mismatch) Error() string {
+	return fmt.Sprintf("%s at offset %d takes %s, got %s", m.OpCode, m.Offset, m.Want, m.Got)
+}
+
+// Result is what Infer learns about a program.
+type Result struct {
+	// Annotations holds the types for each reachable instruction by offset.
+	Annotations map[int]Annotation
+
+	// Mismatches is every instruction given a value of the wrong type, in
+	// order of offset.
+	Mismatches []Mismatch
+}
+
+// stack is the types of the values on the stack of a frame. Types holds the
+// topmost values, deepest first; if the stack is not Exact there may be
+// values of unknown type below them.
+type stack struct {
+	Types []Type
+	Exact bool
+}
+
+// merge returns the stack of a frame which may be either stack.
+func (s stack) merge(other stack) stack {
+	if s.Exact && other.Exact && len(s.Types) == len(other.Types) {
+		types := make([]Type, len(s.Types))
+		for idx := range types {
+			types[idx] = s.Types[idx].merge(other.Types[idx])
+		}
+		return stack{Types: types, Exact: true}
+	}
+	n := min(len(s.Types), len(other.Types))
+	a, b := s.Types[len(s.Types)-n:], other.Types[len(other.Types)-n:]
+	types := make([]Type, n)
+	for idx := range types {
+		types[idx] = a[idx].merge(b[idx])
+	}
+	return stack{Types: types}
+}
+
+// equal checks if the stacks are the same.
+func (s stack) equal(other stack) bool {
+	if s.Exact != other.Exact || len(s.Types) != len(other.Types) {
+		return false
+	}
+	for idx := range s.Types {
+		if s.Types[idx] != other.Types[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// top returns the types of the n topmost values, deepest first.
+func (s stack) top(n int) []Type {
+	types := make([]Type, n)
+	for idx := 1; idx <= n && idx <= len(s.Types); idx++ {
+		types[n-idx] = s.Types[len(s.Types)-idx]
+	}
+	return types
+}
+
+// pop returns the stack without the n topmost values.
+func (s stack) pop(n int) stack {
+	if n > len(s.Types) {
+		n = len(s.Types)
+	}
+	return stack{Types: append([]Type{}, s.Types[:len(s.Types)-n]...), Exact: s.Exact}
+}
+
+// push returns the stack with the values added on top.
+func (s stack) push(types ...Type) stack {
+	return stack{Types: append(append([]Type{}, s.Types...), types...), Exact: s.Exact}
+}
+
+// context is a function being checked. The same code called with a different
+// number of arguments is checked separately.
+type context struct {
+	Entry int
+	Argc  int
+	Top   bool // If this is the top level of the program, which Return ends
+}
+
+// site is an instruction in a context.
+type site struct {
+	Context context
+	Offset  int
+}
+
+type checker struct {
+	code    op.ByteCode
+	insts   map[int]op.Instruction
+	states  map[context]map[int]stack
+	returns map[context]*stack // The values each function returns; nil until a Return is reached
+	callers map[context][]site
+	work    []site
+}
+
+// Infer infers the types of the values on the stack before and after every
+// reachable instruction of the program, starting with an empty stack.
+//
+// The program is checked with verify.Verify first, and its error is returned
+// if it is rejected.
+func Infer(code op.ByteCode) (*Result, error) {
+	if _, err := verify.Verify(code); err != nil {
+		return nil, err
+	}
+	c := &checker{
+		code:    code,
+		insts:   make(map[int]op.Instruction, len(code)),
+		states:  map[context]map[int]stack{},
+		returns: map[context]*stack{},
+		callers: map[context][]site{},
+	}
+	for offset := 0; offset < len(code); {
+		inst, _ := op.Decode(code, offset)
+		c.insts[offset] = inst
+		offset = inst.Next()
+	}
+
+	top := context{Entry: 0, Top: true}
+	c.flow(site{top, 0}, stack{Exact: true})
+	for len(c.work) > 0 {
+		next := c.work[0]
+		c.work = c.work[1:]
+		c.step(next, nil)
+	}
+	return c.result(), nil
+}
+
+// flow merges the stack into the state before the instruction, queueing the
+// instruction if the state changed. Branches which leave the code are ignored.
+func (c *checker) flow(to site, s stack) {
+	if _, ok := c.insts[to.Offset]; !ok {
+		return
+	}
+	states := c.states[to.Context]
+	if states == nil {
+		states = map[int]stack{}
+		c.states[to.Context] = states
+	}
+	if old, ok := states[to.Offset]; ok {
+		if s = old.merge(s); s.equal(old) {
+			return
+		}
+	}
+	states[to.Offset] = s
+	c.work = append(c.work, to)
+}
+
+// step applies the instruction to the state before it and flows the result
+// to the instructions which may run next. If result is not nil, the
+// annotation and any mismatch of the instruction are added to it.
+func (c *checker) step(at site, result *Result) {
+	inst := c.insts[at.Offset]
+	in := c.states[at.Context][at.Offset]
+	next := site{at.Context, inst.Next()}
+	meta, _ := op.Info(inst.Op)
+	var ann Annotation
+	var mismatch *Mismatch
+	var out stack
+
+	switch inst.Op {
+	case op.Halt:
+	case op.PopN:
+		n := int(uint32(inst.Args[0]))
+		ann.Pops = in.top(n)
+		c.flow(next, in.pop(n))
+	case op.Copy:
+		t := TypeUnknown
+		if k := int(uint32(inst.Args[0])); in.Exact && k < len(in.Types) {
+			t = in.Types[k]
+		}
+		ann.Pushes = []Type{t}
+		c.flow(next, in.push(t))
+	case op.Swap:
+		ann.Pops = in.top(1)
+		out = in.pop(0)
+		if k := int(uint32(inst.Args[0])); in.Exact && k < len(in.Types) {
+			last := len(out.Types) - 1
+			out.Types[k], out.Types[last] = out.Types[last], out.Types[k]
+		} else {
+			// The slot swapped with the top can't be found
+			for idx := range out.Types {
+				out.Types[idx] = TypeUnknown
+			}
+		}
+		ann.Pushes = out.top(1)
+		c.flow(next, out)
+	case op.Negative, op.Increment, op.Decrement:
+		// These keep the type of their operand
+		ann.Pops = in.top(1)
+		ann.Pushes = ann.Pops
+		c.flow(next, in.pop(1).push(ann.Pops...))
+	case op.Eq, op.Ne, op.Lt, op.Le, op.Gt, op.Ge, op.Cmp:
+		ann.Pops = in.top(2)
+		ann.Pushes = []Type{TypeInt}
+		if a, b := ann.Pops[0], ann.Pops[1]; a != TypeUnknown && b != TypeUnknown && a != b {
+			mismatch = &Mismatch{Offset: inst.Offset, OpCode: inst.Op.String(), Want: a, Got: b}
+		}
+		c.flow(next, in.pop(2).push(TypeInt))
+	case op.Call:
+		c.call(at, inst, in, &ann)
+	case op.Return:
+		n := int(uint32(inst.Args[0]))
+		ann.Pops = in.top(n)
+		if !at.Context.Top {
+			c.ret(at.Context, stack{Types: ann.Pops, Exact: true})
+		}
+	case op.NativeCall:
+		// The values a native returns are only known at runtime
+		ann.Pops = in.top(int(uint32(inst.Args[1])))
+		c.flow(next, stack{})
+	default:
+		sig := signatures[inst.Op]
+		ann.Pops = in.top(len(sig.Pops))
+		ann.Pushes = append([]Type(nil), sig.Pushes...)
+		// The topmost value is taken first, so it is the first to fail
+		for idx := len(sig.Pops) - 1; idx >= 0 && mismatch == nil; idx-- {
+			want, got := sig.Pops[idx], ann.Pops[idx]
+			if want != TypeUnknown && got != TypeUnknown && want != got {
+				mismatch = &Mismatch{Offset: inst.Offset, OpCode: inst.Op.String(), Want: want, Got: got}
+			}
+		}
+		out = in.pop(len(sig.Pops)).push(sig.Pushes...)
+		if target, ok := inst.Target(); ok {
+			c.flow(site{at.Context, target}, out)
+			if !meta.Is(op.FlagConditional) {
+				break
+			}
+		}
+		c.flow(next, out)
+	}
+
+	if result != nil {
+		result.add(inst.Offset, ann, mismatch)
+	}
+}
+
+// call applies a Call instruction. The function is checked as a context of
+// its own with the types of the arguments of every Call to it, and the
+// types after the Call are known once the function is known to return.
+func (c *checker) call(at site, inst op.Instruction, in stack, ann *Annotation) {
+	argc := int(uint32(inst.Args[1]))
+	target, _ := inst.Target()
+	callee := context{Entry: target, Argc: argc}
+	ann.Pops = in.top(argc)
+
+	known := false
+	for _, caller := range c.callers[callee] {
+		known = known || caller == at
+	}
+	if !known {
+		c.callers[callee] = append(c.callers[callee], at)
+	}
+	c.flow(site{callee, target}, stack{Types: ann.Pops, Exact: true})
+
+	results := c.returns[callee]
+	if results == nil {
+		return
+	}
+	out := in.pop(argc)
+	if results.Exact {
+		ann.Pushes = append([]Type(nil), results.Types...)
+		out = out.push(results.Types...)
+	} else {
+		out = stack{Types: results.Types}
+	}
+	c.flow(site{at.Context, inst.Next()}, out)
+}
+
+// ret records the values a function may return, and requeues its callers if
+// that is new.
+func (c *checker) ret(ctx context, results stack) {
+	if old := c.returns[ctx]; old != nil {
+		merged := old.merge(results)
+		if merged.equal(*old) {
+			return
+		}
+		results = merged
+	}
+	c.returns[ctx] = &results
+	c.work = append(c.work, c.callers[ctx]...)
+}
+
+// result builds the Result from the final state before every instruction.
+func (c *checker) result() *Result {
+	result := &Result{Annotations: map[int]Annotation{}}
+	contexts := map[int]int{}
+	for ctx, states := range c.states {
+		for offset := range states {
+			contexts[offset]++
+			c.step(site{ctx, offset}, result)
+		}
+	}
+	c.work = nil
+
+	// An instruction is only reported if it is given the same wrong type in
+	// every context it runs in; in the others it may run without failing
+	mismatches := make([]Mismatch, 0, len(result.Mismatches))
+	for offset := 0; offset < len(c.code); offset = c.insts[offset].Next() {
+		count := 0
+		var first Mismatch
+		for _, m := range result.Mismatches {
+			if m.Offset != offset {
+				continue
+			}
+			if count == 0 {
+				first = m
+			} else if m != first {
+				break
+			}
+			count++
+		}
+		if count > 0 && count == contexts[offset] {
+			mismatches = append(mismatches, first)
+		}
+	}
+	result.Mismatches = mismatches
+	return result
+}
+
+// add merges the annotation of an instruction in one context into the
+// result, and records the mismatch if there is one.
+func (r *Result) add(offset int, ann Annotation, mismatch *Mismatch) {
+	if old, ok := r.Annotations[offset]; ok {
+		ann.Pops = mergeTypes(old.Pops, ann.Pops)
+		ann.Pushes = mergeTypes(old.Pushes, ann.Pushes)
+	}
+	r.Annotations[offset] = ann
+	if mismatch != nil {
+		r.Mismatches = append(r.Mismatches, *mismatch)
+	}
+}
+
+// mergeTypes merges two lists of types; lists of different lengths can't be
+// merged and give nil.
+func mergeTypes(a, b []Type) []Type {
+	if len(a) != len(b) {
+		return nil
+	}
+	types := make([]Type, len(a))
+	for idx := range types {
+		types[idx] = a[idx].merge(b[idx])
+	}
+	return types
+}
+
+// signature is the types an instruction takes and the types it returns. A
+// TypeUnknown in Pops accepts any type.
+type signature struct {
+	Pops   []Type
+	Pushes []Type
+}
+
+// signatures holds the signature of every opcode which is not handled by the
+// checker itself.
+var signatures = map[op.Op]signature{
+	op.Noop:        {},
+	op.PushInt32:   {nil, []Type{TypeInt}},
+	op.PushInt64:   {nil, []Type{TypeInt}},
+	op.PushUint32:  {nil, []Type{TypeUint}},
+	op.PushUint64:  {nil, []Type{TypeUint}},
+	op.PushFloat32: {nil, []Type{TypeFloat}},
+	op.PushFloat64: {nil, []Type{TypeFloat}},
+	op.Pop:         {[]Type{TypeUnknown}, nil},
+
+	op.AddInt: {[]Type{TypeInt, TypeInt}, []Type{TypeInt}},
+	op.SubInt: {[]Type{TypeInt, TypeInt}, []Type{TypeInt}},
+	op.MulInt: {[]Type{TypeInt, TypeInt}, []Type{TypeInt}},
+	op.DivInt: {[]Type{TypeInt, TypeInt}, []Type{TypeInt}},
+
+	op.Jump:               {},
+	op.JumpIfZero:         {[]Type{TypeUnknown}, nil},
+	op.JumpIfNotZero:      {[]Type{TypeUnknown}, nil},
+	op.JumpIfZeroInt:      {[]Type{TypeInt}, nil},
+	op.JumpIfZeroUint:     {[]Type{TypeUint}, nil},
+	op.JumpIfZeroFloat:    {[]Type{TypeFloat}, nil},
+	op.JumpIfNotZeroInt:   {[]Type{TypeInt}, nil},
+	op.JumpIfNotZeroUint:  {[]Type{TypeUint}, nil},
+	op.JumpIfNotZeroFloat: {[]Type{TypeFloat}, nil},
+}
+
+func init() {
+	// Each group of const opcodes takes and returns the type of its constant
+	consts := [...]Type{TypeInt, TypeInt, TypeUint, TypeUint, TypeFloat, TypeFloat}
+	for _, first := range []op.Op{op.AddConstInt32, op.SubConstInt32, op.MulConstInt32, op.DivConstInt32} {
+		for idx, t := range consts {
+			signatures[first+op.Op(idx)] = signature{[]Type{t}, []Type{t}}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package typecheck_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/typecheck"
+	"github.com/tvarney/gotvm/verify"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+// m builds the expected Mismatch for an instruction.
+func m(offset int, opcode op.Op, want, got typecheck.Type) typecheck.Mismatch {
+	return typecheck.Mismatch{Offset: offset, OpCode: opcode.String(), Want: want, Got: got}
+}
+
+func TestInfer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Source   string
+		Expected []typecheck.Mismatch
+	}{
+		{"empty", "", []typecheck.Mismatch{}},
+		{"add-ints", "PushI32 1\nPushI64 2\nAddInt", []typecheck.Mismatch{}},
+		{"add-float", "PushI32 1\nPushF64 2\nAddInt", []typecheck.Mismatch{m(5, op.AddInt, typecheck.TypeInt, typecheck.TypeFloat)}},
+		{"first-operand", "PushU32 1\nPushI32 2\nAddInt", []typecheck.Mismatch{m(4, op.AddInt, typecheck.TypeInt, typecheck.TypeUint)}},
+		{"const", "PushF32 1\nAddConstU32 2", []typecheck.Mismatch{m(2, op.AddConstUint32, typecheck.TypeUint, typecheck.TypeFloat)}},
+		{"compare", "PushU32 1\nPushI32 1\nEq", []typecheck.Mismatch{m(4, op.Eq, typecheck.TypeUint, typecheck.TypeInt)}},
+		{"compare-same", "PushF32 1\nPushF32 1\nCmp\nJumpIfZeroInt end\nend:", []typecheck.Mismatch{}},
+		{"jump", "PushF32 1\nJumpIfZeroUint end\nend:", []typecheck.Mismatch{m(2, op.JumpIfZeroUint, typecheck.TypeUint, typecheck.TypeFloat)}},
+		{"keeps-type", "PushU32 1\nIncrement\nNegative\nDecrement\nAddConstI32 1", []typecheck.Mismatch{m(5, op.AddConstInt32, typecheck.TypeInt, typecheck.TypeUint)}},
+		{"copy", "PushF32 1\nPushI32 1\nCopy 0\nAddInt", []typecheck.Mismatch{m(6, op.AddInt, typecheck.TypeInt, typecheck.TypeFloat)}},
+		{"swap", "PushF32 1\nPushI32 1\nSwap 0\nAddConstI32 1", []typecheck.Mismatch{m(6, op.AddConstInt32, typecheck.TypeInt, typecheck.TypeFloat)}},
+		{
+			"merge-unknown",
+			"PushI32 0\nJumpIfZero float\nPushI32 1\nJump end\nfloat:\nPushF32 1\nend:\nAddConstI32 1",
+			[]typecheck.Mismatch{},
+		},
+		{
+			"merge-same",
+			"PushI32 0\nJumpIfZero other\nPushU32 1\nJump end\nother:\nPushU32 2\nend:\nAddConstI32 1",
+			[]typecheck.Mismatch{m(10, op.AddConstInt32, typecheck.TypeInt, typecheck.TypeUint)},
+		},
+		{
+			"function",
+			"PushF32 1\nCall f 1\nAddConstF32 1\nHalt\nf:\nCopy 0\nAddConstU32 1\nReturn 1",
+			[]typecheck.Mismatch{
+				m(5, op.AddConstFloat32, typecheck.TypeFloat, typecheck.TypeUint),
+				m(10, op.AddConstUint32, typecheck.TypeUint, typecheck.TypeFloat),
+			},
+		},
+		{
+			"function-result",
+			"Call f 0\nAddConstF32 1\nHalt\nf:\nPushU32 1\nReturn 1",
+			[]typecheck.Mismatch{m(3, op.AddConstFloat32, typecheck.TypeFloat, typecheck.TypeUint)},
+		},
+		{
+			// f also runs at the top level, where it is given an int
+			"contexts",
+			"PushF32 1\nCall f 1\nDivConstI64 1\nf:\nMulConstF32 1\nReturn 1",
+			[]typecheck.Mismatch{m(5, op.DivConstInt64, typecheck.TypeInt, typecheck.TypeFloat)},
+		},
+		{"native", "PushU32 1\nNativeCall 0 0\nAddConstF32 1", []typecheck.Mismatch{}},
+		{"unreachable", "Halt\nPushF32 1\nAddConstI32 1", []typecheck.Mismatch{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := typecheck.Infer(vmtest.Assemble(t, test.Source))
+			require.NoError(t, err)
+			assert.Equal(t, test.Expected, result.Mismatches)
+			for _, mismatch := range result.Mismatches {
+				assert.ErrorIs(t, mismatch, vmerr.ErrInvalidType)
+			}
+		})
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	t.Parallel()
+
+	code := vmtest.Assemble(t, "PushU32 1\nPushF32 2\nCopy 0\nCmp\nPopN 2")
+	result, err := typecheck.Infer(code)
+	require.NoError(t, err)
+
+	u, f, i := typecheck.TypeUint, typecheck.TypeFloat, typecheck.TypeInt
+	assert.Equal(t, map[int]typecheck.Annotation{
+		0: {Pops: []typecheck.Type{}, Pushes: []typecheck.Type{u}},
+		2: {Pops: []typecheck.Type{}, Pushes: []typecheck.Type{f}},
+		4: {Pushes: []typecheck.Type{u}},
+		6: {Pops: []typecheck.Type{f, u}, Pushes: []typecheck.Type{i}},
+		7: {Pops: []typecheck.Type{u, i}},
+	}, result.Annotations)
+}
+
+func TestInferRejected(t *testing.T) {
+	t.Parallel()
+
+	result, err := typecheck.Infer(op.ByteCode{op.Pop})
+	assert.ErrorIs(t, err, vmerr.ErrTooFewValues)
+	var verr verify.Error
+	assert.True(t, errors.As(err, &verr))
+	assert.Nil(t, result)
+}
+
+// typeOf returns the Type of a value on the stack of the reference VM.
+func typeOf(v interface{}) typecheck.Type {
+	switch v.(type) {
+	case int64:
+		return typecheck.TypeInt
+	case uint64:
+		return typecheck.TypeUint
+	case float64:
+		return typecheck.TypeFloat
+	}
+	return typecheck.TypeUnknown
+}
+
+// checkTracer checks that the values popped by each instruction have the
+// types inferred for them.
+type checkTracer struct {
+	vmopt.NopTracer
+	t      *testing.T
+	code   op.ByteCode
+	result *typecheck.Result
+}
+
+func (c *checkTracer) OnInstruction(offset int, opcode op.Op, stack vmopt.StackView) {
+	ann, ok := c.result.Annotations[offset]
+	if !assert.True(c.t, ok, "%s at offset %d is not annotated in %v", opcode, offset, c.code) {
+		return
+	}
+	for idx, want := range ann.Pops {
+		got := typeOf(stack.At(stack.Len() - len(ann.Pops) + idx))
+		if want != typecheck.TypeUnknown {
+			assert.Equal(c.t, want, got, "%s at offset %d in %v", opcode, offset, c.code)
+		}
+	}
+}
+
+// check runs the program on the reference VM, checking the inferred types
+// against the values on the stack. In strict mode, the first instruction
+// with a mismatch which runs must fail.
+func check(t *testing.T, code op.ByteCode, result *typecheck.Result) {
+	t.Helper()
+
+	tracer := &checkTracer{t: t, code: code, result: result}
+	vmtest.RunReferenceSteps(code, fuzzSteps, vmopt.WithTracer(tracer))
+
+	recorder := &vmtest.Recorder{}
+	run, _ := vmtest.RunReferenceSteps(code, fuzzSteps, vmopt.WithStrict(true), vmopt.WithTracer(recorder))
+	mismatches := map[int]bool{}
+	for _, mismatch := range result.Mismatches {
+		mismatches[mismatch.Offset] = true
+	}
+	for _, event := range recorder.Events {
+		var offset int
+		var name string
+		if n, _ := fmt.Sscanf(event, "%d: %s", &offset, &name); n == 2 && mismatches[offset] {
+			var rerr vmerr.RuntimeError
+			require.True(t, errors.As(run.Err, &rerr), "%s at offset %d did not fail in %v", name, offset, code)
+			assert.Equal(t, offset, rerr.Offset)
+			assert.ErrorIs(t, run.Err, vmerr.ErrInvalidType)
+			return
+		}
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	t.Parallel()
+
+	for name, code := range vmtest.Corpus(t) {
+		code := code
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := typecheck.Infer(code)
+			require.NoError(t, err)
+			check(t, code, result)
+		})
+	}
+}
+
+// fuzzSteps is the instruction budget for programs run by the tests
+const fuzzSteps = 10000
+
+func FuzzInfer(f *testing.F) {
+	f.Add([]byte{})
+	for _, source := range []string{
+		"PushI32 1\nPushF64 2\nAddInt",
+		"PushF32 1\nCall f 1\nAddConstF32 1\nHalt\nf:\nCopy 0\nAddConstU32 1\nReturn 1",
+	} {
+		f.Add(vmtest.BytesFromCode(vmtest.Assemble(f, source)))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		code := vmtest.CodeFromBytes(data)
+		result, err := typecheck.Infer(code)
+		if err != nil {
+			return
+		}
+		check(t, code, result)
+	})
+}
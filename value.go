@@ -0,0 +1,125 @@
+package gotvm
+
+import (
+	"math"
+	"strconv"
+	"unsafe"
+)
+
+// Kind is the type of a Value.
+type Kind uint8
+
+const (
+	KindInvalid Kind = iota
+	KindInt
+	KindUint
+	KindFloat
+)
+
+// String returns the name of the kind.
+func (k Kind) String() string {
+	switch k {
+	case KindInt:
+		return "int"
+	case KindUint:
+		return "uint"
+	case KindFloat:
+		return "float"
+	}
+	return "invalid"
+}
+
+// Value is a single value on the stack of the VirtualMachine.
+//
+// A Value is a type tag and a 64-bit payload, which avoids allocating for
+// every value pushed to the stack. The zero Value is invalid.
+type Value struct {
+	kind Kind
+	bits uint64
+
+	// ptr is reserved for values which are allocated on the heap, such as
+	// strings and objects.
+	ptr unsafe.Pointer
+}
+
+// Int returns a Value holding an int64.
+func Int(v int64) Value {
+	return Value{kind: KindInt, bits: uint64(v)}
+}
+
+// Uint returns a Value holding a uint64.
+func Uint(v uint64) Value {
+	return Value{kind: KindUint, bits: v}
+}
+
+// Float returns a Value holding a float64.
+func Float(v float64) Value {
+	return Value{kind: KindFloat, bits: math.Float64bits(v)}
+}
+
+// ValueOf returns a Value holding the given int64, uint64 or float64. Any
+// other type results in an invalid Value.
+func ValueOf(v interface{}) Value {
+	switch value := v.(type) {
+	case int64:
+		return Int(value)
+	case uint64:
+		return Uint(value)
+	case float64:
+		return Float(value)
+	}
+	return Value{}
+}
+
+// Kind returns the type of the value.
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+// Int returns the value converted to an int64, following the same conversion
+// rules as the opcodes of the VM. An invalid value returns 0.
+func (v Value) Int() int64 {
+	i, _ := coerceInt(v)
+	return i
+}
+
+// Uint returns the value converted to a uint64, following the same conversion
+// rules as the opcodes of the VM. An invalid value returns 0.
+func (v Value) Uint() uint64 {
+	u, _ := coerceUint(v)
+	return u
+}
+
+// Float returns the value converted to a float64, following the same
+// conversion rules as the opcodes of the VM. An invalid value returns 0.
+func (v Value) Float() float64 {
+	f, _ := coerceFloat(v)
+	return f
+}
+
+// Interface returns the value as an int64, uint64 or float64 according to its
+// kind, or nil if the value is invalid.
+func (v Value) Interface() interface{} {
+	switch v.kind {
+	case KindInt:
+		return int64(v.bits)
+	case KindUint:
+		return v.bits
+	case KindFloat:
+		return math.Float64frombits(v.bits)
+	}
+	return nil
+}
+
+// String returns a string representation of the value.
+func (v Value) String() string {
+	switch v.kind {
+	case KindInt:
+		return strconv.FormatInt(int64(v.bits), 10)
+	case KindUint:
+		return strconv.FormatUint(v.bits, 10)
+	case KindFloat:
+		return strconv.FormatFloat(math.Float64frombits(v.bits), 'g', -1, 64)
+	}
+	return "<invalid>"
+}
@@ -0,0 +1,56 @@
+package gotvm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm"
+)
+
+func TestValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name      string
+		Value     gotvm.Value
+		Kind      gotvm.Kind
+		Int       int64
+		Uint      uint64
+		Float     float64
+		Interface interface{}
+		String    string
+	}{
+		{"int", gotvm.Int(-2), gotvm.KindInt, -2, math.MaxUint64 - 1, -2, int64(-2), "-2"},
+		{"uint", gotvm.Uint(7), gotvm.KindUint, 7, 7, 7, uint64(7), "7"},
+		{"float", gotvm.Float(2.5), gotvm.KindFloat, 2, 2, 2.5, float64(2.5), "2.5"},
+		{"value-of-int", gotvm.ValueOf(int64(3)), gotvm.KindInt, 3, 3, 3, int64(3), "3"},
+		{"value-of-uint", gotvm.ValueOf(uint64(4)), gotvm.KindUint, 4, 4, 4, uint64(4), "4"},
+		{"value-of-float", gotvm.ValueOf(0.5), gotvm.KindFloat, 0, 0, 0.5, float64(0.5), "0.5"},
+		{"value-of-other", gotvm.ValueOf("str"), gotvm.KindInvalid, 0, 0, 0, nil, "<invalid>"},
+		{"zero", gotvm.Value{}, gotvm.KindInvalid, 0, 0, 0, nil, "<invalid>"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.Kind, test.Value.Kind())
+			assert.Equal(t, test.Int, test.Value.Int())
+			assert.Equal(t, test.Uint, test.Value.Uint())
+			assert.Equal(t, test.Float, test.Value.Float())
+			assert.Equal(t, test.Interface, test.Value.Interface())
+			assert.Equal(t, test.String, test.Value.String())
+		})
+	}
+}
+
+func TestKindString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "int", gotvm.KindInt.String())
+	assert.Equal(t, "uint", gotvm.KindUint.String())
+	assert.Equal(t, "float", gotvm.KindFloat.String())
+	assert.Equal(t, "invalid", gotvm.KindInvalid.String())
+}
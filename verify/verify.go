@@ -0,0 +1,399 @@
+// Package verify statically checks bytecode before it is run.
+//
+// Both VMs check every instruction as it runs; a program which passes Verify
+// is known never to fail those checks which depend only on the program
+// itself. A verified program has no undefined opcodes or truncated operands,
+// branches only to the start of an instruction or to the end of the code, and
+// never pops below the base of its frame on any path.
+//
+// Checks which depend on the values on the stack (types, division by zero)
+// or on the configuration of the VM (natives, gas and limits) are still made
+// at runtime.
+package verify
+
+import (
+	"strconv"
+
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/vmerr"
+)
+
+const (
+	ErrInvalidTarget = vmerr.ConstError("invalid branch target")
+)
+
+// Unbounded is the value of Depth.Max and Info.MaxStack if the number of
+// values on the stack has no static limit; e.g. a loop which pushes a value
+// on each iteration, or a native call which returns an unknown number of
+// values.
+const Unbounded = -1
+
+// widenAfter is the number of times the depth at an instruction may grow
+// before it is taken to be unbounded. Without widening a loop which pushes a
+// value on each iteration would never converge.
+const widenAfter = 8
+
+// InvalidTargetError is an error type wrapping the ErrInvalidTarget constant
+// error with the branching opcode and the offset it branches to.
+type InvalidTargetError struct {
+	OpCode string
+	Target int
+}
+
+func (e InvalidTargetError) Unwrap() error {
+	return ErrInvalidTarget
+}
+
+func (e InvalidTargetError) Error() string {
+	return string(ErrInvalidTarget) + " " + strconv.Itoa(e.Target) + " for " + e.OpCode
+}
+
+// Error wraps the reason a program was rejected with the offset of the
+// instruction at fault. The wrapped error is one of the vmerr errors the VMs
+// would raise at runtime, or an InvalidTargetError.
+type Error struct {
+	Err    error
+	Offset int
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+func (e Error) Error() string {
+	return e.Err.Error() + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// Depth is the range of the number of values on the stack above the base of
+// the current frame.
+type Depth struct {
+	Min int
+	Max int // Unbounded if there is no limit
+}
+
+// merge returns the smallest range containing both ranges.
+func (d Depth) merge(other Depth) Depth {
+	result := Depth{Min: min(d.Min, other.Min), Max: max(d.Max, other.Max)}
+	if d.Max == Unbounded || other.Max == Unbounded {
+		result.Max = Unbounded
+	}
+	return result
+}
+
+// add returns the range moved by n values.
+func (d Depth) add(n int) Depth {
+	if d.Max != Unbounded {
+		d.Max += n
+	}
+	d.Min += n
+	return d
+}
+
+// Info is what Verify learns about a program.
+type Info struct {
+	// MaxStack is the most values the program has on the stack at once,
+	// counting the frames of every active function, or Unbounded.
+	MaxStack int
+
+	// Depths is the depth of the stack before each reachable instruction,
+	// relative to the base of the frame it runs in. Instructions which are
+	// not in the map are never run.
+	Depths map[int]Depth
+}
+
+// context is a function being verified. The same code called with a
+// different number of arguments is verified separately.
+type context struct {
+	Entry int
+	Argc  int
+	Top   bool // If this is the top level of the program, which Return ends
+}
+
+// site is an instruction in a context.
+type site struct {
+	Context context
+	Offset  int
+}
+
+// call is a Call in a context, recorded for MaxStack.
+type call struct {
+	Base   int // The most values below the callee's frame, relative to the caller's frame
+	Callee context
+}
+
+// state is the depth of the stack before an instruction in a context.
+type state struct {
+	Depth   Depth
+	Updates int
+}
+
+type verifier struct {
+	code    op.ByteCode
+	insts   map[int]op.Instruction
+	states  map[context]map[int]*state
+	returns map[context]*Depth // The values each function returns; nil until a Return is reached
+	callers map[context][]site
+	calls   map[context]map[int]*call // The Calls in each context by offset
+	peaks   map[context]int
+	work    []site
+}
+
+// Verify checks the program, returning what it learned about it. If the
+// program is rejected, the error is an Error recording the offset of the
+// first problem found.
+//
+// The stack is assumed to be empty when the program starts; values already on
+// the stack of a VM are never popped by a verified program.
+func Verify(code op.ByteCode) (*Info, error) {
+	v := &verifier{
+		code:    code,
+		insts:   make(map[int]op.Instruction, len(code)),
+		states:  map[context]map[int]*state{},
+		returns: map[context]*Depth{},
+		callers: map[context][]site{},
+		calls:   map[context]map[int]*call{},
+		peaks:   map[context]int{},
+	}
+	if err := v.decode(); err != nil {
+		return nil, err
+	}
+	if len(code) > 0 {
+		top := context{Entry: 0, Top: true}
+		v.flow(site{top, 0}, Depth{})
+		for len(v.work) > 0 {
+			next := v.work[0]
+			v.work = v.work[1:]
+			if err := v.step(next); err != nil {
+				return nil, Error{Err: err, Offset: next.Offset}
+			}
+		}
+	}
+	return v.info(), nil
+}
+
+// decode decodes every instruction, checking that the opcodes are defined,
+// that no operands are missing and that every branch target is valid.
+func (v *verifier) decode() error {
+	for offset := 0; offset < len(v.code); {
+		inst, err := op.Decode(v.code, offset)
+		if err != nil {
+			return Error{Err: err, Offset: offset}
+		}
+		v.insts[offset] = inst
+		offset = inst.Next()
+	}
+	for offset := 0; offset < len(v.code); offset = v.insts[offset].Next() {
+		inst := v.insts[offset]
+		if target, ok := inst.Target(); ok && !v.valid(target) {
+			return Error{Err: InvalidTargetError{OpCode: inst.Op.String(), Target: target}, Offset: offset}
+		}
+	}
+	return nil
+}
+
+// valid checks if execution may continue at the offset; either the start of
+// an instruction or the end of the code, which ends the program.
+func (v *verifier) valid(offset int) bool {
+	_, ok := v.insts[offset]
+	return ok || offset == len(v.code)
+}
+
+// flow merges the depth into the state before the instruction, queueing the
+// instruction if the state changed.
+func (v *verifier) flow(to site, depth Depth) {
+	if to.Offset == len(v.code) {
+		// Running off the end of the code ends the program
+		return
+	}
+	states := v.states[to.Context]
+	if states == nil {
+		states = map[int]*state{}
+		v.states[to.Context] = states
+	}
+	s, ok := states[to.Offset]
+	if !ok {
+		states[to.Offset] = &state{Depth: depth}
+		v.work = append(v.work, to)
+		return
+	}
+	merged := s.Depth.merge(depth)
+	if merged == s.Depth {
+		return
+	}
+	if s.Updates++; s.Updates > widenAfter && merged.Max != s.Depth.Max {
+		merged.Max = Unbounded
+	}
+	s.Depth = merged
+	v.work = append(v.work, to)
+}
+
+// peak records the depth reached in a context for MaxStack.
+func (v *verifier) peak(ctx context, depth Depth) {
+	peak, ok := v.peaks[ctx]
+	switch {
+	case !ok, depth.Max == Unbounded:
+		v.peaks[ctx] = depth.Max
+	case peak != Unbounded && depth.Max > peak:
+		v.peaks[ctx] = depth.Max
+	}
+}
+
+// step applies an instruction to the state before it, and flows the result
+// to the instructions which may run next.
+func (v *verifier) step(at site) error {
+	inst := v.insts[at.Offset]
+	in := v.states[at.Context][at.Offset].Depth
+	meta, _ := op.Info(inst.Op)
+	next := site{at.Context, inst.Next()}
+	v.peak(at.Context, in)
+
+	tooFew := vmerr.TooFewValuesError{OpCode: inst.Op.String()}
+	switch inst.Op {
+	case op.Halt:
+		return nil
+	case op.PopN:
+		n := int(uint32(inst.Args[0]))
+		if n == 0 || n > in.Min {
+			return tooFew
+		}
+		v.flow(next, in.add(-n))
+		return nil
+	case op.Copy, op.Swap:
+		if int(uint32(inst.Args[0])) >= in.Min {
+			return vmerr.IndexOutOfBoundsError{OpCode: inst.Op.String()}
+		}
+	case op.Return:
+		n := int(uint32(inst.Args[0]))
+		if n > in.Min {
+			return tooFew
+		}
+		if !at.Context.Top {
+			v.ret(at.Context, Depth{Min: n, Max: n})
+		}
+		return nil
+	case op.NativeCall:
+		argc := int(uint32(inst.Args[1]))
+		if argc > in.Min {
+			return tooFew
+		}
+		// The number of values a native returns is only known at runtime
+		out := Depth{Min: in.Min - argc, Max: Unbounded}
+		v.peak(at.Context, out)
+		v.flow(next, out)
+		return nil
+	case op.Call:
+		return v.call(at, inst, in)
+	}
+
+	if meta.Pops > in.Min {
+		return tooFew
+	}
+	out := in.add(meta.Pushes - meta.Pops)
+	v.peak(at.Context, out)
+	if target, ok := inst.Target(); ok {
+		v.flow(site{at.Context, target}, out)
+		if !meta.Is(op.FlagConditional) {
+			return nil
+		}
+	}
+	v.flow(next, out)
+	return nil
+}
+
+// call applies a Call instruction. The function is verified as a context of
+// its own, and execution continues after the Call once the function is known
+// to return.
+func (v *verifier) call(at site, inst op.Instruction, in Depth) error {
+	argc := int(uint32(inst.Args[1]))
+	if argc > in.Min {
+		return vmerr.TooFewValuesError{OpCode: inst.Op.String()}
+	}
+	target, _ := inst.Target()
+	callee := context{Entry: target, Argc: argc}
+
+	base := in.add(-argc).Max
+	calls := v.calls[at.Context]
+	if calls == nil {
+		calls = map[int]*call{}
+		v.calls[at.Context] = calls
+	}
+	if c, ok := calls[at.Offset]; ok {
+		// The depth before the call may have grown, moving the callee's frame
+		if base == Unbounded || (c.Base != Unbounded && base > c.Base) {
+			c.Base = base
+		}
+	} else {
+		calls[at.Offset] = &call{Base: base, Callee: callee}
+		v.callers[callee] = append(v.callers[callee], at)
+		v.flow(site{callee, target}, Depth{Min: argc, Max: argc})
+	}
+
+	if results := v.returns[callee]; results != nil {
+		out := in.add(-argc).addRange(*results)
+		v.peak(at.Context, out)
+		v.flow(site{at.Context, inst.Next()}, out)
+	}
+	return nil
+}
+
+// addRange returns the range with the values of another range added on top.
+func (d Depth) addRange(other Depth) Depth {
+	d.Min += other.Min
+	if d.Max == Unbounded || other.Max == Unbounded {
+		d.Max = Unbounded
+	} else {
+		d.Max += other.Max
+	}
+	return d
+}
+
+// ret records that the function may return the given number of values, and
+// requeues its callers if that is new.
+func (v *verifier) ret(ctx context, results Depth) {
+	if old := v.returns[ctx]; old != nil {
+		if merged := old.merge(results); merged != *old {
+			*old = merged
+		} else {
+			return
+		}
+	} else {
+		v.returns[ctx] = &results
+	}
+	v.work = append(v.work, v.callers[ctx]...)
+}
+
+// info builds the Info of the verified program.
+func (v *verifier) info() *Info {
+	info := &Info{Depths: map[int]Depth{}}
+	for _, states := range v.states {
+		for offset, s := range states {
+			if d, ok := info.Depths[offset]; ok {
+				info.Depths[offset] = d.merge(s.Depth)
+			} else {
+				info.Depths[offset] = s.Depth
+			}
+		}
+	}
+	info.MaxStack = v.maxStack(context{Entry: 0, Top: true}, map[context]bool{})
+	return info
+}
+
+// maxStack returns the most values on the stack while the context runs,
+// including the functions it calls. Recursion is Unbounded.
+func (v *verifier) maxStack(ctx context, active map[context]bool) int {
+	if active[ctx] {
+		return Unbounded
+	}
+	active[ctx] = true
+	defer delete(active, ctx)
+
+	peak := v.peaks[ctx]
+	for _, c := range v.calls[ctx] {
+		callee := v.maxStack(c.Callee, active)
+		if peak == Unbounded || c.Base == Unbounded || callee == Unbounded {
+			return Unbounded
+		}
+		peak = max(peak, c.Base+callee)
+	}
+	return peak
+}
@@ -0,0 +1,172 @@
+package verify_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/verify"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	b := func(values ...op.Op) op.ByteCode {
+		return values
+	}
+
+	tests := []struct {
+		Name             string
+		Code             op.ByteCode
+		ExpectedMaxStack int
+		ExpectedError    error
+		ExpectedOffset   int
+	}{
+		{"empty", nil, 0, nil, 0},
+		{"halt", b(op.Halt), 0, nil, 0},
+		{"push-pop", b(op.PushInt32, 1, op.PushInt64, 0, 2, op.AddInt, op.Pop), 2, nil, 0},
+		{"unreachable", b(op.Halt, op.Pop), 0, nil, 0},
+		{"jump-to-end", b(op.PushInt32, 0, op.JumpIfZero, 2), 1, nil, 0},
+		{"invalid-opcode", b(op.Noop, op.Op(0xDEAD)), 0, vmerr.ErrInvalidOpcode, 1},
+		{"unreachable-invalid-opcode", b(op.Halt, op.Op(0xDEAD)), 0, vmerr.ErrInvalidOpcode, 1},
+		{"truncated", b(op.Noop, op.PushInt64, 1), 0, vmerr.ErrMissingConstArg, 1},
+		{"jump-into-operand", b(op.PushInt32, 1, op.Jump, 0xFFFFFFFF), 0, verify.ErrInvalidTarget, 2},
+		{"jump-past-end", b(op.Jump, 3), 0, verify.ErrInvalidTarget, 0},
+		{"jump-before-start", b(op.Noop, op.Jump, 0xFFFFFFFE), 0, verify.ErrInvalidTarget, 1},
+		{"call-into-operand", b(op.Call, 1, 0), 0, verify.ErrInvalidTarget, 0},
+		{"pop-empty", b(op.Pop), 0, vmerr.ErrTooFewValues, 0},
+		{"add-one-value", b(op.PushInt32, 1, op.AddInt), 0, vmerr.ErrTooFewValues, 2},
+		{"popn-zero", b(op.PushInt32, 1, op.PopN, 0), 0, vmerr.ErrTooFewValues, 2},
+		{"popn-too-many", b(op.PushInt32, 1, op.PopN, 2), 0, vmerr.ErrTooFewValues, 2},
+		{"copy-out-of-range", b(op.PushInt32, 1, op.Copy, 1), 0, vmerr.ErrIndexOutOfBounds, 2},
+		{"swap-empty", b(op.Swap, 0), 0, vmerr.ErrIndexOutOfBounds, 0},
+		{"return-too-many", b(op.Return, 1), 0, vmerr.ErrTooFewValues, 0},
+		{"native-args", b(op.NativeCall, 0, 1), 0, vmerr.ErrTooFewValues, 0},
+		{"native-results", b(op.PushInt32, 1, op.NativeCall, 0, 1, op.Pop), 0, vmerr.ErrTooFewValues, 5},
+		{
+			// PushI32 1; JumpIfZero +4; PushI32 2; Pop
+			"underflow-on-one-path",
+			b(op.PushInt32, 1, op.JumpIfZero, 4, op.PushInt32, 2, op.Pop),
+			0, vmerr.ErrTooFewValues, 6,
+		},
+		{
+			// PushI32 1; Call +4 1; Pop; Halt; f: Return 1
+			"call",
+			b(op.PushInt32, 1, op.Call, 5, 1, op.Pop, op.Halt, op.Return, 1),
+			1, nil, 0,
+		},
+		{
+			// PushI32 1; Call +4 1; Pop; Pop; f: Return 1
+			"call-results",
+			b(op.PushInt32, 1, op.Call, 5, 1, op.Pop, op.Pop, op.Return, 1),
+			0, vmerr.ErrTooFewValues, 6,
+		},
+		{
+			// PushI32 1; PushI32 2; Call +3 1; Halt; f: Pop; Pop; Return 0
+			"pop-below-frame",
+			b(op.PushInt32, 1, op.PushInt32, 2, op.Call, 4, 1, op.Halt, op.Pop, op.Pop, op.Return, 0),
+			0, vmerr.ErrTooFewValues, 9,
+		},
+		{
+			// PushI32 1; PushI32 2; Call +4 1; Halt; f: PushI32 3; PushI32 4; Return 2
+			"nested-max-stack",
+			b(op.PushInt32, 1, op.PushInt32, 2, op.Call, 4, 1, op.Halt, op.PushInt32, 3, op.PushInt32, 4, op.Return, 2),
+			4, nil, 0,
+		},
+		{"recursion", b(op.PushInt32, 1, op.Call, 0, 1), verify.Unbounded, nil, 0},
+		{"push-loop", b(op.PushInt32, 1, op.Jump, 0xFFFFFFFE), verify.Unbounded, nil, 0},
+		{"native", b(op.NativeCall, 0, 0), verify.Unbounded, nil, 0},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			info, err := verify.Verify(test.Code)
+			if test.ExpectedError != nil {
+				assert.ErrorIs(t, err, test.ExpectedError)
+				var verr verify.Error
+				require.True(t, errors.As(err, &verr))
+				assert.Equal(t, test.ExpectedOffset, verr.Offset)
+				assert.Nil(t, info)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.ExpectedMaxStack, info.MaxStack)
+		})
+	}
+}
+
+func TestDepths(t *testing.T) {
+	t.Parallel()
+
+	// PushI32 1; JumpIfZero +4; PushI32 2; PushI32 3; Halt
+	code := op.ByteCode{op.PushInt32, 1, op.JumpIfZero, 4, op.PushInt32, 2, op.PushInt32, 3, op.Halt}
+	info, err := verify.Verify(code)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]verify.Depth{
+		0: {Min: 0, Max: 0},
+		2: {Min: 1, Max: 1},
+		4: {Min: 0, Max: 0},
+		6: {Min: 0, Max: 1},
+		8: {Min: 1, Max: 2},
+	}, info.Depths)
+}
+
+func TestCorpus(t *testing.T) {
+	t.Parallel()
+
+	for name, code := range vmtest.Corpus(t) {
+		code := code
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			info, err := verify.Verify(code)
+			require.NoError(t, err)
+			if info.MaxStack != verify.Unbounded {
+				result := vmtest.Compare(t, code, vmopt.WithMaxStack(info.MaxStack))
+				assert.NotErrorIs(t, result.Err, vmerr.ErrStackOverflow)
+			}
+		})
+	}
+}
+
+// fuzzSteps is the instruction budget for programs run by FuzzVerify
+const fuzzSteps = 10000
+
+func FuzzVerify(f *testing.F) {
+	for _, code := range vmtest.Corpus(f) {
+		f.Add(vmtest.BytesFromCode(code))
+	}
+
+	// A verified program must never fail a check which Verify covers
+	f.Fuzz(func(t *testing.T, data []byte) {
+		code := vmtest.CodeFromBytes(data)
+
+		info, err := verify.Verify(code)
+		if err != nil {
+			return
+		}
+		var opts []vmopt.Option
+		if info.MaxStack != verify.Unbounded {
+			opts = append(opts, vmopt.WithMaxStack(info.MaxStack))
+		}
+		result, _ := vmtest.RunReferenceSteps(code, fuzzSteps, opts...)
+		var rerr vmerr.RuntimeError
+		if !errors.As(result.Err, &rerr) {
+			return
+		}
+		for _, covered := range []error{vmerr.ErrInvalidOpcode, vmerr.ErrMissingConstArg, vmerr.ErrTooFewValues, vmerr.ErrStackOverflow} {
+			assert.NotErrorIs(t, result.Err, covered, "bytecode %v", code)
+		}
+		if rerr.OpCode != op.NativeCall.String() {
+			assert.NotErrorIs(t, result.Err, vmerr.ErrIndexOutOfBounds, "bytecode %v", code)
+		}
+	})
+}
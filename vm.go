@@ -1,50 +1,320 @@
 package gotvm
 
 import (
+	"math"
+
+	"github.com/tvarney/gotvm/op"
 	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
 )
 
 // VirtualMachine is a stack based VM which executes bytecode.
+//
+// If GasLimit is not zero, each opcode is charged its cost from the op
+// package before it is executed and execution stops with an
+// vmerr.OutOfGasError once the limit would be exceeded. GasUsed holds the gas
+// consumed by the last call to Execute, whether or not a limit is set.
+//
+// If MaxStack is not zero, opcodes which would grow the stack past MaxStack
+// values fail with a vmerr.StackOverflowError. If MaxFrames is not zero, a
+// Call which would make more than MaxFrames frames active fails with a
+// vmerr.CallDepthExceededError.
+//
+// The remaining fields mirror the vmopt.Config the VM was created with.
 type VirtualMachine struct {
-	Stack     []interface{}
+	Stack     []Value
 	FrameBase int
+	Frames    []Frame
+	GasLimit  uint64
+	GasUsed   uint64
+	MaxStack  int
+	MaxFrames int
+	Tracer    vmopt.Tracer
+	Natives   []vmopt.Native
+	Strict    bool
+	Debug     *op.DebugInfo
+}
+
+// Option configures a VirtualMachine. The options are defined in the vmopt
+// package and are shared with the reference VM.
+type Option = vmopt.Option
+
+// ContextCheckInterval is the number of opcodes ExecuteContext runs between
+// checks of its context for cancellation.
+const ContextCheckInterval = 1024
+
+// Frame is the record of an active function call.
+//
+// A frame is pushed by the Call opcode and popped by the Return opcode; it
+// holds the state of the caller which must be restored on return.
+type Frame struct {
+	ReturnAddress int
+	FrameBase     int
+	Entry         int // The offset of the function which was called
 }
 
-// New returns a new VirtualMachine instance with a pre-allocated stack.
-func New() *VirtualMachine {
+// New returns a new VirtualMachine instance configured by the given options.
+// Without options the stack is pre-allocated to vmopt.DefaultStackSize values
+// and no limits are set.
+func New(opts ...Option) *VirtualMachine {
+	config := vmopt.New(opts...)
 	return &VirtualMachine{
-		Stack:     make([]interface{}, 0, 1024),
+		Stack:     make([]Value, 0, config.StackSize),
 		FrameBase: 0,
+		GasLimit:  config.GasLimit,
+		MaxStack:  config.MaxStack,
+		MaxFrames: config.MaxFrames,
+		Tracer:    config.Tracer,
+		Natives:   config.Natives,
+		Strict:    config.Strict,
+		Debug:     config.Debug,
 	}
 }
 
 // NewWithSize returns a new VirtualMachine instance with a Stack pre-allocated
 // to the given size.
+//
+// Deprecated: Use New(vmopt.WithStackSize(size)) instead.
 func NewWithSize(size int) *VirtualMachine {
-	if size < 0 {
-		size = 1024
-	}
-	return &VirtualMachine{
-		Stack:     make([]interface{}, 0, size),
-		FrameBase: 0,
-	}
+	return New(vmopt.WithStackSize(size))
 }
 
 // Helpers
 // =======
 
-func (vm *VirtualMachine) push(v interface{}) {
+func (vm *VirtualMachine) push(v Value) {
 	vm.Stack = append(vm.Stack, v)
 }
 
-func coerceInt(v interface{}) (int64, error) {
-	switch value := v.(type) {
-	case int64:
-		return value, nil
-	case uint64:
-		return int64(value), nil
-	case float64:
-		return int64(value), nil
+// The to functions convert a value for an opcode which takes the given type.
+// In strict mode only values of exactly that type are accepted.
+
+func (vm *VirtualMachine) toInt(v Value) (int64, bool) {
+	if vm.Strict && v.kind != KindInt {
+		return 0, false
+	}
+	return coerceInt(v)
+}
+
+func (vm *VirtualMachine) toUint(v Value) (uint64, bool) {
+	if vm.Strict && v.kind != KindUint {
+		return 0, false
+	}
+	return coerceUint(v)
+}
+
+func (vm *VirtualMachine) toFloat(v Value) (float64, bool) {
+	if vm.Strict && v.kind != KindFloat {
+		return 0, false
+	}
+	return coerceFloat(v)
+}
+
+// compare compares two values for the comparison opcodes; in strict mode both
+// values must have the same type.
+func (vm *VirtualMachine) compare(a, b Value) (int, bool, bool) {
+	if vm.Strict && a.kind != b.kind {
+		return 0, false, false
+	}
+	return compare(a, b)
+}
+
+// stackView implements vmopt.StackView for the stack of a VirtualMachine.
+// Converting a *VirtualMachine to a *stackView does not allocate.
+type stackView VirtualMachine
+
+func (s *stackView) Len() int {
+	return len(s.Stack)
+}
+
+func (s *stackView) At(idx int) interface{} {
+	return s.Stack[idx].Interface()
+}
+
+// stackTrace returns the active functions, innermost first.
+func (vm *VirtualMachine) stackTrace() []vmerr.Frame {
+	trace := make([]vmerr.Frame, 0, len(vm.Frames)+1)
+	base := vm.FrameBase
+	for idx := len(vm.Frames) - 1; idx >= 0; idx-- {
+		frame := vm.Frames[idx]
+		trace = append(trace, vmerr.Frame{
+			Function:  vm.Debug.Symbol(frame.Entry),
+			Entry:     frame.Entry,
+			CallSite:  frame.ReturnAddress - op.Call.Size(),
+			FrameBase: base,
+		})
+		base = frame.FrameBase
+	}
+	return append(trace, vmerr.Frame{
+		Function:  vm.Debug.Symbol(0),
+		Entry:     0,
+		CallSite:  -1,
+		FrameBase: base,
+	})
+}
+
+// runtimeError wraps an error raised by the instruction at the given offset
+// with the location and the top of the stack. The opcodes of this VM check
+// their operands before they change the stack, so it is still as it was when
+// the failing instruction started.
+func (vm *VirtualMachine) runtimeError(code op.ByteCode, offset int, err error) error {
+	top := vm.Stack[max(0, len(vm.Stack)-vmerr.StackSnapshotSize):]
+	stack := make([]interface{}, len(top))
+	for i, v := range top {
+		stack[i] = v.Interface()
+	}
+	return vmerr.RuntimeError{
+		Err:    err,
+		Offset: offset,
+		OpCode: code[offset].String(),
+		Line:   vm.Debug.Line(offset),
+		Stack:  stack,
+		Frames: vm.stackTrace(),
+	}
+}
+
+// callNative calls the native with the topmost argc values, replacing them
+// with its results.
+func (vm *VirtualMachine) callNative(name string, native vmopt.Native, argc int) error {
+	first := len(vm.Stack) - argc
+	args := make([]interface{}, argc)
+	for i, v := range vm.Stack[first:] {
+		args[i] = v.Interface()
+	}
+	results, err := native.Func(args)
+	if err != nil {
+		return vmerr.NativeError{OpCode: name, Name: native.Name, Err: err}
+	}
+	for _, result := range results {
+		if ValueOf(result).kind == KindInvalid {
+			return vmerr.InvalidTypeError{OpCode: name}
+		}
+	}
+	if vm.MaxStack != 0 && first+len(results) > vm.MaxStack {
+		return vmerr.StackOverflowError{OpCode: name}
+	}
+	vm.Stack = vm.Stack[:first]
+	for _, result := range results {
+		vm.push(ValueOf(result))
+	}
+	return nil
+}
+
+// full checks if pushing another value would exceed MaxStack.
+func (vm *VirtualMachine) full() bool {
+	return vm.MaxStack != 0 && len(vm.Stack) >= vm.MaxStack
+}
+
+// The coerce functions convert a value on the stack to the given type. The
+// second return value is false if the value is not a known type.
+
+func coerceInt(v Value) (int64, bool) {
+	switch v.kind {
+	case KindInt, KindUint:
+		return int64(v.bits), true
+	case KindFloat:
+		return int64(math.Float64frombits(v.bits)), true
+	}
+	return 0, false
+}
+
+func coerceUint(v Value) (uint64, bool) {
+	switch v.kind {
+	case KindInt, KindUint:
+		return v.bits, true
+	case KindFloat:
+		return uint64(math.Float64frombits(v.bits)), true
+	}
+	return 0, false
+}
+
+func coerceFloat(v Value) (float64, bool) {
+	switch v.kind {
+	case KindInt:
+		return float64(int64(v.bits)), true
+	case KindUint:
+		return float64(v.bits), true
+	case KindFloat:
+		return math.Float64frombits(v.bits), true
+	}
+	return 0, false
+}
+
+// isZero checks if the value is the zero value of its own type. The second
+// return value is false if the value is not a known type.
+func isZero(v Value) (bool, bool) {
+	switch v.kind {
+	case KindInt, KindUint:
+		return v.bits == 0, true
+	case KindFloat:
+		return math.Float64frombits(v.bits) == 0, true
+	}
+	return false, false
+}
+
+// compare compares the values a and b, returning -1, 0 or 1 as a is less than,
+// equal to or greater than b.
+//
+// The second return value is false if the values are unordered, which is the
+// case when either is a NaN. The third return value is false if either value
+// is not a known type.
+//
+// Mixed int64 and uint64 values are compared by their mathematical value. If
+// either value is a float64, both are compared as float64 values.
+func compare(a, b Value) (int, bool, bool) {
+	if a.kind == KindInvalid || b.kind == KindInvalid {
+		return 0, false, false
+	}
+	if a.kind == KindFloat || b.kind == KindFloat {
+		af, _ := coerceFloat(a)
+		bf, _ := coerceFloat(b)
+		return compareFloat(af, bf)
+	}
+	switch {
+	case a.kind == b.kind && a.kind == KindInt:
+		return compareOrdered(int64(a.bits), int64(b.bits)), true, true
+	case a.kind == b.kind:
+		return compareOrdered(a.bits, b.bits), true, true
+	case a.kind == KindInt && int64(a.bits) < 0:
+		return -1, true, true
+	case b.kind == KindInt && int64(b.bits) < 0:
+		return 1, true, true
+	}
+	return compareOrdered(a.bits, b.bits), true, true
+}
+
+func compareOrdered[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareFloat compares two floats for compare. The first return value is -1,
+// 0 or 1 as a is less than, equal to or greater than b; a NaN counts as less
+// than any other value and equal to another NaN, which is the order Cmp uses.
+// The second return value is false if either value is a NaN, as the values
+// are then unordered; Eq, Lt, Le, Gt and Ge push 0 and Ne pushes 1 for them.
+// The third return value is always true, as two floats can always be compared.
+func compareFloat(a, b float64) (int, bool, bool) {
+	aNaN, bNaN := math.IsNaN(a), math.IsNaN(b)
+	switch {
+	case aNaN && bNaN:
+		return 0, false, true
+	case aNaN:
+		return -1, false, true
+	case bNaN:
+		return 1, false, true
+	}
+	return compareOrdered(a, b), true, true
+}
+
+func boolInt(v bool) int64 {
+	if v {
+		return 1
 	}
-	return 0, vmerr.ConstError("can't coerce value to int")
+	return 0
 }
@@ -1,6 +1,9 @@
 package vmerr
 
-import "strconv"
+import (
+	"errors"
+	"strconv"
+)
 
 const (
 	ErrTooFewValues     ConstError = "too few arguments on stack"
@@ -8,6 +11,13 @@ const (
 	ErrIndexOutOfBounds ConstError = "index out of bounds"
 	ErrMissingConstArg  ConstError = "missing const arg"
 	ErrInvalidOpcode    ConstError = "invalid opcode"
+	ErrDivisionByZero   ConstError = "division by zero"
+	ErrIntegerOverflow  ConstError = "integer overflow"
+	ErrOutOfGas         ConstError = "out of gas"
+	ErrInterrupted      ConstError = "interrupted"
+	ErrStackOverflow    ConstError = "stack overflow"
+	ErrCallDepth        ConstError = "call depth exceeded"
+	ErrNative           ConstError = "native function failed"
 )
 
 // TooFewValuesError is an error type wrapping the ErrTooFewValues constant
@@ -77,5 +87,159 @@ func (e InvalidOpcodeError) Unwrap() error {
 }
 
 func (e InvalidOpcodeError) Error() string {
-	return string(ErrInvalidOpcode) + "0x" + strconv.FormatUint(uint64(e.OpCode), 16)
+	return string(ErrInvalidOpcode) + " 0x" + strconv.FormatUint(uint64(e.OpCode), 16)
+}
+
+// DivisionByZeroError is an error type wrapping the ErrDivisionByZero constant
+// error with the opcode which attempted the division.
+type DivisionByZeroError struct {
+	OpCode string
+}
+
+func (e DivisionByZeroError) Unwrap() error {
+	return ErrDivisionByZero
+}
+
+func (e DivisionByZeroError) Error() string {
+	return string(ErrDivisionByZero) + " for " + e.OpCode
+}
+
+// IntegerOverflowError is an error type wrapping the ErrIntegerOverflow
+// constant error with the opcode which overflowed.
+type IntegerOverflowError struct {
+	OpCode string
+}
+
+func (e IntegerOverflowError) Unwrap() error {
+	return ErrIntegerOverflow
+}
+
+func (e IntegerOverflowError) Error() string {
+	return string(ErrIntegerOverflow) + " for " + e.OpCode
+}
+
+// OutOfGasError is an error type wrapping the ErrOutOfGas constant error with
+// the opcode which could not be paid for and its offset in the bytecode.
+type OutOfGasError struct {
+	OpCode string
+	Offset int
+}
+
+func (e OutOfGasError) Unwrap() error {
+	return ErrOutOfGas
+}
+
+func (e OutOfGasError) Error() string {
+	return string(ErrOutOfGas) + " for " + e.OpCode + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// InterruptedError is an error type wrapping both the ErrInterrupted constant
+// error and the error of the context which stopped execution, with the opcode
+// which was about to run and its offset in the bytecode.
+type InterruptedError struct {
+	OpCode string
+	Offset int
+	Err    error
+}
+
+func (e InterruptedError) Unwrap() []error {
+	return []error{ErrInterrupted, e.Err}
+}
+
+func (e InterruptedError) Error() string {
+	return string(ErrInterrupted) + " before " + e.OpCode + " at offset " + strconv.Itoa(e.Offset) + ": " + e.Err.Error()
+}
+
+// StackOverflowError is an error type wrapping the ErrStackOverflow constant
+// error with the opcode which would have grown the stack past its limit.
+type StackOverflowError struct {
+	OpCode string
+}
+
+func (e StackOverflowError) Unwrap() error {
+	return ErrStackOverflow
+}
+
+func (e StackOverflowError) Error() string {
+	return string(ErrStackOverflow) + " for " + e.OpCode
+}
+
+// CallDepthExceededError is an error type wrapping the ErrCallDepth constant
+// error with the opcode which would have pushed a frame past the limit.
+type CallDepthExceededError struct {
+	OpCode string
+}
+
+func (e CallDepthExceededError) Unwrap() error {
+	return ErrCallDepth
+}
+
+func (e CallDepthExceededError) Error() string {
+	return string(ErrCallDepth) + " for " + e.OpCode
+}
+
+// NativeError is an error type wrapping both the ErrNative constant error and
+// the error returned by a native function, with the opcode which called it and
+// the name of the native.
+type NativeError struct {
+	OpCode string
+	Name   string
+	Err    error
+}
+
+func (e NativeError) Unwrap() []error {
+	return []error{ErrNative, e.Err}
+}
+
+func (e NativeError) Error() string {
+	return string(ErrNative) + " for " + e.OpCode + " " + e.Name + ": " + e.Err.Error()
+}
+
+// Frame describes a function which was active when a RuntimeError was raised.
+type Frame struct {
+	Function  string // The name of the function if symbols are known
+	Entry     int    // The offset of the first instruction of the function
+	CallSite  int    // The offset of the Call which entered the function; -1 for the top level
+	FrameBase int    // The index of the first stack value of the frame
+}
+
+// StackTrace returns the frames recorded by a RuntimeError in the chain of
+// err, innermost first, or nil if there is none.
+func StackTrace(err error) []Frame {
+	var runtimeErr RuntimeError
+	if errors.As(err, &runtimeErr) {
+		return runtimeErr.Frames
+	}
+	return nil
+}
+
+// StackSnapshotSize is the number of values from the top of the stack which
+// are recorded in a RuntimeError.
+const StackSnapshotSize = 8
+
+// RuntimeError wraps an error raised while a VM executed a program with the
+// location of the failing instruction and the top of the stack as it was when
+// the instruction started.
+//
+// A RuntimeError unwraps to the error it wraps, so errors.Is still matches
+// the constant errors in this package.
+type RuntimeError struct {
+	Err    error
+	Offset int           // The offset of the failing instruction
+	OpCode string        // The mnemonic of the failing instruction
+	Line   int           // The source line of the instruction; 0 if unknown
+	Stack  []interface{} // Up to StackSnapshotSize values, ending with the top
+	Frames []Frame       // The active functions, innermost first
+}
+
+func (e RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func (e RuntimeError) Error() string {
+	msg := e.Err.Error() + " at offset " + strconv.Itoa(e.Offset)
+	if e.Line > 0 {
+		msg += " (line " + strconv.Itoa(e.Line) + ")"
+	}
+	return msg
 }
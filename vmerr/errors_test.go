@@ -0,0 +1,58 @@
+package vmerr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm/vmerr"
+)
+
+func TestErrorTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		Error         error
+		ExpectedError error
+		ExpectedText  string
+	}{
+		{"too-few-values", vmerr.TooFewValuesError{OpCode: "Pop"}, vmerr.ErrTooFewValues, "too few arguments on stack for Pop"},
+		{"invalid-type", vmerr.InvalidTypeError{OpCode: "Negative"}, vmerr.ErrInvalidType, "invalid type for Negative"},
+		{"index-out-of-bounds", vmerr.IndexOutOfBoundsError{OpCode: "Copy"}, vmerr.ErrIndexOutOfBounds, "index out of bounds for Copy"},
+		{"missing-const-arg", vmerr.MissingConstArgError{OpCode: "PushI32"}, vmerr.ErrMissingConstArg, "missing const arg for PushI32"},
+		{"invalid-opcode", vmerr.InvalidOpcodeError{OpCode: 0xFF}, vmerr.ErrInvalidOpcode, "invalid opcode 0xff"},
+		{"division-by-zero", vmerr.DivisionByZeroError{OpCode: "DivInt"}, vmerr.ErrDivisionByZero, "division by zero for DivInt"},
+		{"integer-overflow", vmerr.IntegerOverflowError{OpCode: "DivInt"}, vmerr.ErrIntegerOverflow, "integer overflow for DivInt"},
+		{"interrupted", vmerr.InterruptedError{OpCode: "Jump", Offset: 3, Err: context.Canceled}, vmerr.ErrInterrupted, "interrupted before Jump at offset 3: context canceled"},
+		{"interrupted-context", vmerr.InterruptedError{OpCode: "Jump", Offset: 3, Err: context.Canceled}, context.Canceled, "interrupted before Jump at offset 3: context canceled"},
+		{"stack-overflow", vmerr.StackOverflowError{OpCode: "PushI32"}, vmerr.ErrStackOverflow, "stack overflow for PushI32"},
+		{"call-depth-exceeded", vmerr.CallDepthExceededError{OpCode: "Call"}, vmerr.ErrCallDepth, "call depth exceeded for Call"},
+		{"native", vmerr.NativeError{OpCode: "NativeCall", Name: "sqrt", Err: context.Canceled}, vmerr.ErrNative, "native function failed for NativeCall sqrt: context canceled"},
+		{"native-error", vmerr.NativeError{OpCode: "NativeCall", Name: "sqrt", Err: context.Canceled}, context.Canceled, "native function failed for NativeCall sqrt: context canceled"},
+		{"runtime", vmerr.RuntimeError{Err: vmerr.TooFewValuesError{OpCode: "Pop"}, Offset: 4, OpCode: "Pop"}, vmerr.ErrTooFewValues, "too few arguments on stack for Pop at offset 4"},
+		{"runtime-line", vmerr.RuntimeError{Err: vmerr.ErrInvalidType, Offset: 4, OpCode: "AddInt", Line: 7}, vmerr.ErrInvalidType, "invalid type at offset 4 (line 7)"},
+		{"out-of-gas", vmerr.OutOfGasError{OpCode: "Jump", Offset: 12}, vmerr.ErrOutOfGas, "out of gas for Jump at offset 12"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.True(t, errors.Is(test.Error, test.ExpectedError))
+			assert.Equal(t, test.ExpectedText, test.Error.Error())
+		})
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	t.Parallel()
+
+	frames := []vmerr.Frame{{Function: "f", Entry: 8, CallSite: 2}, {CallSite: -1}}
+	err := fmt.Errorf("running: %w", vmerr.RuntimeError{Err: vmerr.ErrTooFewValues, Frames: frames})
+	assert.Equal(t, frames, vmerr.StackTrace(err))
+	assert.Nil(t, vmerr.StackTrace(vmerr.ErrTooFewValues))
+	assert.Nil(t, vmerr.StackTrace(nil))
+}
@@ -0,0 +1,14 @@
+package vmopt
+
+// NativeFunc is the implementation of a native function.
+//
+// The arguments are in the order they were pushed and are each an int64,
+// uint64 or float64. The returned values are pushed in order and must also be
+// one of those types.
+type NativeFunc func(args []interface{}) ([]interface{}, error)
+
+// Native is a Go function which bytecode may call via the NativeCall opcode.
+type Native struct {
+	Name string
+	Func NativeFunc
+}
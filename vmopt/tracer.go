@@ -0,0 +1,51 @@
+package vmopt
+
+import "github.com/tvarney/gotvm/op"
+
+// Tracer is notified by a VM as it executes a program.
+//
+// A VM only calls a Tracer which was installed with WithTracer; without one
+// the VM does no tracing work at all. The StackView passed to a Tracer is only
+// valid until the method returns.
+type Tracer interface {
+	// OnInstruction is called before the opcode at the given offset runs.
+	OnInstruction(offset int, opcode op.Op, stack StackView)
+	// OnCall is called after a Call at the given offset entered the function
+	// at target; depth is the number of active frames.
+	OnCall(offset, target, depth int)
+	// OnReturn is called after a Return at the given offset resumed the
+	// caller at target; depth is the number of active frames.
+	OnReturn(offset, target, depth int)
+	// OnError is called with the error which stopped the program.
+	OnError(err error)
+	// OnHalt is called when the program finishes without an error.
+	OnHalt(stack StackView)
+}
+
+// StackView gives a Tracer read access to the stack of a VM.
+type StackView interface {
+	// Len returns the number of values on the stack.
+	Len() int
+	// At returns the value at the given index as an int64, uint64 or
+	// float64, where 0 is the bottom of the stack.
+	At(idx int) interface{}
+}
+
+// Values returns a copy of all of the values of a StackView.
+func Values(stack StackView) []interface{} {
+	values := make([]interface{}, stack.Len())
+	for idx := range values {
+		values[idx] = stack.At(idx)
+	}
+	return values
+}
+
+// NopTracer is a Tracer which ignores every event. It may be embedded in a
+// type which only implements some of the methods of Tracer.
+type NopTracer struct{}
+
+func (NopTracer) OnInstruction(offset int, opcode op.Op, stack StackView) {}
+func (NopTracer) OnCall(offset, target, depth int)                        {}
+func (NopTracer) OnReturn(offset, target, depth int)                      {}
+func (NopTracer) OnError(err error)                                       {}
+func (NopTracer) OnHalt(stack StackView)                                  {}
@@ -0,0 +1,101 @@
+// Package vmopt holds the options shared by the VM in the main package and the
+// reference VM.
+//
+// Both New functions take a list of Options which are applied in order to a
+// Config; options added later override earlier ones. New knobs are added here
+// as new Option functions so that existing callers never need to change.
+package vmopt
+
+import "github.com/tvarney/gotvm/op"
+
+// DefaultStackSize is the number of values the stack is pre-allocated to hold
+// if no WithStackSize option is given.
+const DefaultStackSize = 1024
+
+// Config is the configuration of a VM built from a list of Options.
+type Config struct {
+	StackSize int           // The initial capacity of the stack
+	MaxStack  int           // The maximum number of values on the stack; 0 is unlimited
+	MaxFrames int           // The maximum number of active call frames; 0 is unlimited
+	GasLimit  uint64        // The maximum gas a program may use; 0 is unlimited
+	Tracer    Tracer        // Notified as the program runs; nil disables tracing
+	Natives   []Native      // The functions callable via the NativeCall opcode
+	Strict    bool          // Disables implicit conversions between types
+	Debug     *op.DebugInfo // Used to report source lines in errors
+}
+
+// Option is a function which changes a Config.
+type Option func(*Config)
+
+// New returns a Config with the defaults and the given options applied.
+func New(opts ...Option) Config {
+	config := Config{StackSize: DefaultStackSize}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// WithStackSize sets the initial capacity of the stack. Negative sizes are
+// replaced by DefaultStackSize.
+func WithStackSize(size int) Option {
+	return func(c *Config) {
+		if size < 0 {
+			size = DefaultStackSize
+		}
+		c.StackSize = size
+	}
+}
+
+// WithMaxStack limits the number of values on the stack.
+func WithMaxStack(max int) Option {
+	return func(c *Config) {
+		c.MaxStack = max
+	}
+}
+
+// WithMaxFrames limits the number of active call frames.
+func WithMaxFrames(max int) Option {
+	return func(c *Config) {
+		c.MaxFrames = max
+	}
+}
+
+// WithGasLimit limits the gas a program may use.
+func WithGasLimit(limit uint64) Option {
+	return func(c *Config) {
+		c.GasLimit = limit
+	}
+}
+
+// WithTracer installs a Tracer.
+func WithTracer(tracer Tracer) Option {
+	return func(c *Config) {
+		c.Tracer = tracer
+	}
+}
+
+// WithNatives appends native functions to the registry. The NativeCall opcode
+// refers to natives by their index in the order they were added.
+func WithNatives(natives ...Native) Option {
+	return func(c *Config) {
+		c.Natives = append(c.Natives, natives...)
+	}
+}
+
+// WithStrict enables or disables strict typing. In strict mode opcodes only
+// accept values of the type they operate on instead of converting them, and
+// comparisons require both values to have the same type.
+func WithStrict(strict bool) Option {
+	return func(c *Config) {
+		c.Strict = strict
+	}
+}
+
+// WithDebugInfo sets the debug info of the program which will be run, which is
+// used to report source lines in runtime errors.
+func WithDebugInfo(debug *op.DebugInfo) Option {
+	return func(c *Config) {
+		c.Debug = debug
+	}
+}
@@ -0,0 +1,39 @@
+package vmopt_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm/vmopt"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, vmopt.Config{StackSize: vmopt.DefaultStackSize}, vmopt.New())
+	})
+	t.Run("LaterOverrides", func(t *testing.T) {
+		t.Parallel()
+		config := vmopt.New(vmopt.WithGasLimit(10), vmopt.WithGasLimit(20))
+		assert.Equal(t, uint64(20), config.GasLimit)
+	})
+	t.Run("NegativeStackSize", func(t *testing.T) {
+		t.Parallel()
+		config := vmopt.New(vmopt.WithStackSize(-1))
+		assert.Equal(t, vmopt.DefaultStackSize, config.StackSize)
+	})
+	t.Run("NativesAppend", func(t *testing.T) {
+		t.Parallel()
+		config := vmopt.New(
+			vmopt.WithNatives(vmopt.Native{Name: "a"}),
+			vmopt.WithNatives(vmopt.Native{Name: "b"}, vmopt.Native{Name: "c"}),
+		)
+		names := []string{}
+		for _, native := range config.Natives {
+			names = append(names, native.Name)
+		}
+		assert.Equal(t, []string{"a", "b", "c"}, names)
+	})
+}
@@ -0,0 +1,99 @@
+package vmtest_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tvarney/gotvm"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/reference"
+)
+
+// benchmarks are small programs which exercise a single opcode. The values
+// used are large enough that boxing them in an interface{} allocates, which
+// makes the difference between the stack representations of the two VMs
+// visible in the allocation counts.
+var benchmarks = []struct {
+	Name string
+	Code op.ByteCode
+}{
+	{"PushInt32", op.ByteCode{op.PushInt32, 1000, op.Halt}},
+	{"PushUint64", op.ByteCode{op.PushUint64, 0, 1000, op.Halt}},
+	{"PushFloat64", op.ByteCode{op.PushFloat64, op.Op(math.Float64bits(1.5) >> 32), op.Op(uint32(math.Float64bits(1.5))), op.Halt}},
+	{"AddInt", op.ByteCode{op.PushInt32, 1000, op.PushInt32, 2000, op.AddInt, op.Halt}},
+	{"MulConstInt32", op.ByteCode{op.PushInt32, 1000, op.MulConstInt32, 3, op.Halt}},
+	{"Negative", op.ByteCode{op.PushInt32, 1000, op.Negative, op.Halt}},
+	{"Increment", op.ByteCode{op.PushInt32, 1000, op.Increment, op.Halt}},
+	{"Lt", op.ByteCode{op.PushInt32, 1000, op.PushInt32, 2000, op.Lt, op.Halt}},
+	{"Loop", op.ByteCode{
+		op.PushInt32, 1000,
+		op.Decrement,
+		op.Copy, 0,
+		op.JumpIfNotZero, 0xFFFFFFFD,
+		op.Halt,
+	}},
+	{"Call", op.ByteCode{
+		op.PushInt32, 1000,
+		op.Call, 8, 1,
+		op.Copy, 0,
+		op.JumpIfNotZero, 0xFFFFFFFB,
+		op.Halt,
+		op.Decrement,
+		op.Return, 1,
+	}},
+}
+
+func BenchmarkFast(b *testing.B) {
+	for _, bench := range benchmarks {
+		bench := bench
+		b.Run(bench.Name, func(b *testing.B) {
+			vm := gotvm.New()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				vm.Stack = vm.Stack[:0]
+				if err := vm.Execute(bench.Code); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCompiled runs the benchmarks as programs compiled by gotvm.Compile,
+// to compare against BenchmarkFast. Compiling is not measured.
+func BenchmarkCompiled(b *testing.B) {
+	for _, bench := range benchmarks {
+		bench := bench
+		b.Run(bench.Name, func(b *testing.B) {
+			program, err := gotvm.Compile(bench.Code)
+			if err != nil {
+				b.Fatal(err)
+			}
+			vm := gotvm.New()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vm.Stack = vm.Stack[:0]
+				if err := program.Run(vm); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReference(b *testing.B) {
+	for _, bench := range benchmarks {
+		bench := bench
+		b.Run(bench.Name, func(b *testing.B) {
+			vm := reference.New()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				vm.Stack = vm.Stack[:0]
+				if err := vm.Execute(bench.Code); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
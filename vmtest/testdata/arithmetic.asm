@@ -0,0 +1,23 @@
+; Integer arithmetic on mixed signed and unsigned values
+PushI32 -7
+PushI64 0x100000000
+AddInt              ; 4294967289
+PushU32 3
+MulInt              ; 12884901867
+PushU64 0xFFFFFFFFFFFFFFFF
+SubInt              ; -1 - 12884901867
+PushI32 -3
+DivInt              ; -3 / -12884901868 -> 0
+Negative
+Increment
+Increment
+Decrement
+PushU32 0
+Negative
+Decrement
+PushI32 100
+PushI32 7
+DivInt              ; 7 / 100 -> 0
+PushI32 7
+PushI32 100
+DivInt              ; 100 / 7 -> 14
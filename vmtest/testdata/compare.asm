@@ -0,0 +1,31 @@
+; Comparisons between mixed signed and unsigned values
+PushI32 -1
+PushU64 0xFFFFFFFFFFFFFFFF
+Lt
+PushI32 -1
+PushU64 0xFFFFFFFFFFFFFFFF
+Eq
+PushU32 5
+PushI32 5
+Eq
+PushU32 5
+PushI32 -5
+Gt
+PushI32 3
+PushI32 3
+Le
+PushI32 4
+PushI32 3
+Ge
+PushI32 4
+PushU32 3
+Ne
+PushU32 1
+PushU32 2
+Cmp
+PushI32 -2
+PushU32 2
+Cmp
+PushI32 2
+PushI32 2
+Cmp
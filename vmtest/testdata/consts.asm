@@ -0,0 +1,32 @@
+; Every constant arithmetic opcode
+PushI32 10
+AddConstI32 -3
+AddConstI64 0x100000000
+SubConstI32 5
+SubConstI64 -5
+MulConstI32 -2
+MulConstI64 3
+DivConstI32 4
+DivConstI64 -2
+PushU32 10
+AddConstU32 7
+AddConstU64 0xFFFFFFFFFFFFFFFF
+SubConstU32 20
+SubConstU64 1
+MulConstU32 3
+MulConstU64 2
+DivConstU32 7
+DivConstU64 3
+PushI32 -9
+AddConstF32 0.25
+AddConstF64 1e10
+SubConstF32 1.5
+SubConstF64 -2.5
+MulConstF32 -0.5
+MulConstF64 3.25
+DivConstF32 2.0
+DivConstF64 -0.125
+PushF32 3.0
+DivConstF64 0.0
+PushU32 3
+AddConstI32 -5
@@ -0,0 +1,11 @@
+; Division by zero must be a VM error in both VMs
+PushI32 10
+PushI32 3
+Call divide 2
+Halt
+
+divide:
+    PushI32 0
+    Copy 0
+    DivInt              ; 10 / 0
+    Return 1
@@ -0,0 +1,30 @@
+; Float values and comparisons involving NaN and infinities
+PushF32 1.5
+PushF64 -2.25
+AddInt
+PushF64 NaN
+Copy 1
+Eq
+PushF64 NaN
+PushF64 NaN
+Ne
+PushF64 NaN
+PushI32 1
+Cmp
+PushI32 1
+PushF64 NaN
+Cmp
+PushF64 NaN
+PushF64 NaN
+Cmp
+PushF64 +Inf
+PushU64 0xFFFFFFFFFFFFFFFF
+Gt
+PushF64 -Inf
+Negative
+PushF32 0.5
+Increment
+PushF32 0.5
+Decrement
+PushF64 1e300
+MulConstF64 1e300
@@ -0,0 +1,34 @@
+; Function calls, recursion and frame relative addressing
+    PushI32 7
+    PushI32 10
+    Call factorial 1
+    PushI32 3
+    PushI32 4
+    Call add 2
+    Call noresult 0
+    Halt
+
+; factorial(n) -> n!
+factorial:
+    Copy 0
+    JumpIfNotZero recurse
+    PushI32 1
+    Return 1
+recurse:
+    Copy 0
+    Decrement
+    Call factorial 1
+    MulInt
+    Return 1
+
+; add(a, b) -> a + b
+add:
+    PushI32 99          ; a local discarded by Return
+    Copy 0
+    Copy 1
+    AddInt
+    Return 1
+
+noresult:
+    PushI32 1
+    Return 0
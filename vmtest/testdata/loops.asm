@@ -0,0 +1,45 @@
+; Loops and conditionals using each branch opcode
+    PushI32 0               ; acc
+    PushI32 10              ; n
+loop:
+    Copy 1
+    JumpIfZero done
+    Copy 0
+    Copy 1
+    AddInt
+    Swap 0
+    Pop
+    Copy 1
+    AddConstI32 -1
+    Swap 1
+    Pop
+    Jump loop
+done:
+    PushF32 0.5
+    JumpIfZeroInt truncated
+    PushI32 -1
+truncated:
+    PushF32 0.5
+    JumpIfZeroFloat never
+    PushU32 0
+    JumpIfZeroUint unsigned
+    PushI32 -2
+unsigned:
+    PushI32 -1
+    JumpIfNotZeroUint wrapped
+    PushI32 -3
+wrapped:
+    PushF32 0.25
+    JumpIfNotZeroInt never
+    PushF32 0.25
+    JumpIfNotZeroFloat float
+    PushI32 -4
+float:
+    PushI32 0
+    JumpIfNotZero never
+    PushI32 1
+    JumpIfNotZero end
+never:
+    PushI32 -5
+end:
+    Halt
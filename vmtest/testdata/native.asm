@@ -0,0 +1,3 @@
+; Native calls fail when the index is not in the registry of native functions
+PushI32 1
+NativeCall 0 1
@@ -0,0 +1,18 @@
+; Stack manipulation
+Noop
+PushI32 1
+PushI32 2
+PushI32 3
+PushI32 4
+Copy 0
+Copy 3
+Swap 1
+Swap 0
+Pop
+PushI32 5
+PushI32 6
+PushI32 7
+PopN 2
+Swap 4
+Halt
+PushI32 99           ; not reached
@@ -0,0 +1,257 @@
+// Package vmtest provides helpers to check the VM in the main package against
+// the reference VM.
+//
+// The reference VM is the specification for the main VM; any program should
+// produce the same result on both.
+package vmtest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/tvarney/gotvm"
+	"github.com/tvarney/gotvm/assembler"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/reference"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+)
+
+// Sentinels is the list of constant errors which VM errors are compared by.
+var Sentinels = []error{
+	vmerr.ErrTooFewValues,
+	vmerr.ErrInvalidType,
+	vmerr.ErrIndexOutOfBounds,
+	vmerr.ErrMissingConstArg,
+	vmerr.ErrInvalidOpcode,
+	vmerr.ErrDivisionByZero,
+	vmerr.ErrIntegerOverflow,
+	vmerr.ErrOutOfGas,
+	vmerr.ErrInterrupted,
+	vmerr.ErrStackOverflow,
+	vmerr.ErrCallDepth,
+	vmerr.ErrNative,
+}
+
+// Result is the outcome of running a program on a VM.
+type Result struct {
+	Stack   []interface{}
+	Err     error
+	GasUsed uint64
+}
+
+// RunFast runs the code on the VM in the main package.
+func RunFast(code op.ByteCode, opts ...vmopt.Option) Result {
+	vm := gotvm.New(opts...)
+	err := vm.Execute(code)
+	stack := make([]interface{}, len(vm.Stack))
+	for i, v := range vm.Stack {
+		stack[i] = v.Interface()
+	}
+	return Result{Stack: stack, Err: err, GasUsed: vm.GasUsed}
+}
+
+// RunReference runs the code on the reference VM.
+func RunReference(code op.ByteCode, opts ...vmopt.Option) Result {
+	vm := reference.New(opts...)
+	err := vm.Execute(code)
+	return Result{Stack: vm.Stack, Err: err, GasUsed: vm.GasUsed}
+}
+
+// RunReferenceSteps runs the code on the reference VM for at most the given
+// number of steps. The second return value is false if the program did not
+// finish within that many steps.
+func RunReferenceSteps(code op.ByteCode, steps int, opts ...vmopt.Option) (Result, bool) {
+	vm := reference.New(opts...)
+	vm.Start(code)
+	for i := 0; i < steps; i++ {
+		if err := vm.Step(); err != nil {
+			if err == reference.ErrHalt {
+				err = nil
+			}
+			return Result{Stack: vm.Stack, Err: err, GasUsed: vm.GasUsed}, true
+		}
+	}
+	return Result{Stack: vm.Stack, GasUsed: vm.GasUsed}, false
+}
+
+// Diff describes the differences between the results of the two VMs. An
+// empty string is returned if the results are equivalent.
+//
+// Errors are equivalent if they match the same Sentinels. The stacks are only
+// compared if neither VM returned an error, as the state of the stack after an
+// error is not specified. The gas used is always compared.
+func Diff(fast, ref Result) string {
+	var diffs []string
+	if (fast.Err == nil) != (ref.Err == nil) {
+		diffs = append(diffs, fmt.Sprintf("error: fast VM returned %v, reference VM returned %v", fast.Err, ref.Err))
+	} else if fast.Err != nil {
+		for _, sentinel := range Sentinels {
+			if errors.Is(fast.Err, sentinel) != errors.Is(ref.Err, sentinel) {
+				diffs = append(diffs, fmt.Sprintf(
+					"error: fast VM returned %v, reference VM returned %v; differ on %q",
+					fast.Err, ref.Err, sentinel,
+				))
+			}
+		}
+	} else if !EqualStacks(fast.Stack, ref.Stack) {
+		diffs = append(diffs, fmt.Sprintf("stack: fast VM has %#v, reference VM has %#v", fast.Stack, ref.Stack))
+	}
+	if fast.GasUsed != ref.GasUsed {
+		diffs = append(diffs, fmt.Sprintf("gas: fast VM used %d, reference VM used %d", fast.GasUsed, ref.GasUsed))
+	}
+	return strings.Join(diffs, "\n")
+}
+
+// EqualStacks compares two stacks, treating NaN values of the same type as
+// equal to each other.
+func EqualStacks(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		af, aok := a[idx].(float64)
+		bf, bok := b[idx].(float64)
+		if aok && bok && math.IsNaN(af) && math.IsNaN(bf) {
+			continue
+		}
+		if !reflect.DeepEqual(a[idx], b[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Recorder is a vmopt.Tracer which records every event as a line of text, so
+// that the traces of the two VMs can be compared.
+type Recorder struct {
+	Events []string
+	// Depth is the number of values from the top of the stack recorded with
+	// each instruction, along with the size of the stack. The whole stack is
+	// recorded if it is 0, which is slow for long traces of deep stacks.
+	Depth int
+}
+
+func (r *Recorder) OnInstruction(offset int, opcode op.Op, stack vmopt.StackView) {
+	if r.Depth == 0 {
+		r.Events = append(r.Events, fmt.Sprintf("%d: %s %v", offset, opcode, vmopt.Values(stack)))
+		return
+	}
+	start := stack.Len() - r.Depth
+	if start < 0 {
+		start = 0
+	}
+	top := make([]interface{}, 0, stack.Len()-start)
+	for idx := start; idx < stack.Len(); idx++ {
+		top = append(top, stack.At(idx))
+	}
+	r.Events = append(r.Events, fmt.Sprintf("%d: %s %d %v", offset, opcode, stack.Len(), top))
+}
+
+func (r *Recorder) OnCall(offset, target, depth int) {
+	r.Events = append(r.Events, fmt.Sprintf("%d: call %d depth %d", offset, target, depth))
+}
+
+func (r *Recorder) OnReturn(offset, target, depth int) {
+	r.Events = append(r.Events, fmt.Sprintf("%d: return %d depth %d", offset, target, depth))
+}
+
+// OnError records the location of the error. The message is not recorded, as
+// only the constant errors wrapped are specified to match between the VMs.
+func (r *Recorder) OnError(err error) {
+	var runtimeErr vmerr.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		r.Events = append(r.Events, fmt.Sprintf("%d: error", runtimeErr.Offset))
+		return
+	}
+	r.Events = append(r.Events, "error")
+}
+
+func (r *Recorder) OnHalt(stack vmopt.StackView) {
+	r.Events = append(r.Events, fmt.Sprintf("halt %v", vmopt.Values(stack)))
+}
+
+// Compare runs the code on both VMs created with the given options and fails
+// the test if the results differ. The result of the reference VM is returned.
+func Compare(t testing.TB, code op.ByteCode, opts ...vmopt.Option) Result {
+	t.Helper()
+	ref := RunReference(code, opts...)
+	if diff := Diff(RunFast(code, opts...), ref); diff != "" {
+		t.Errorf("VMs differ for bytecode %v\n%s", code, diff)
+	}
+	return ref
+}
+
+// Assemble assembles the source, failing the test on any assembler error.
+func Assemble(t testing.TB, source string) op.ByteCode {
+	t.Helper()
+	var errs []string
+	report := func(err assembler.AssembleError) {
+		errs = append(errs, fmt.Sprintf("line %d: %s", err.LineNo, err.Message))
+	}
+	code := assembler.Assemble(strings.Split(source, "\n"), report)
+	if len(errs) > 0 {
+		t.Fatalf("failed to assemble:\n%s", strings.Join(errs, "\n"))
+	}
+	return code
+}
+
+// CompareAssembly assembles the source and runs it on both VMs, failing the
+// test if the results differ. The result of the reference VM is returned.
+func CompareAssembly(t testing.TB, source string, opts ...vmopt.Option) Result {
+	t.Helper()
+	return Compare(t, Assemble(t, source), opts...)
+}
+
+// Corpus assembles the programs in the testdata directory of this package,
+// keyed by their file name without the extension. The test fails if any of
+// them can't be read or assembled.
+func Corpus(tb testing.TB) map[string]op.ByteCode {
+	tb.Helper()
+	_, self, _, ok := runtime.Caller(0)
+	if !ok {
+		tb.Fatal("failed to locate the vmtest testdata directory")
+	}
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(self), "testdata", "*.asm"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("failed to find the corpus: %v", err)
+	}
+
+	programs := make(map[string]op.ByteCode, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		programs[strings.TrimSuffix(filepath.Base(file), ".asm")] = Assemble(tb, string(content))
+	}
+	return programs
+}
+
+// CodeFromBytes decodes the input of a fuzz test into bytecode, taking every
+// four bytes as a little endian opcode or operand. Trailing bytes are ignored.
+func CodeFromBytes(data []byte) op.ByteCode {
+	code := make(op.ByteCode, len(data)/4)
+	for idx := range code {
+		code[idx] = op.Op(binary.LittleEndian.Uint32(data[idx*4:]))
+	}
+	return code
+}
+
+// BytesFromCode encodes the bytecode as the input of a fuzz test; it is the
+// inverse of CodeFromBytes.
+func BytesFromCode(code op.ByteCode) []byte {
+	data := make([]byte, 0, len(code)*4)
+	for _, value := range code {
+		data = binary.LittleEndian.AppendUint32(data, uint32(value))
+	}
+	return data
+}
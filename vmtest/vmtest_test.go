@@ -0,0 +1,164 @@
+package vmtest_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tvarney/gotvm/op"
+	"github.com/tvarney/gotvm/vmerr"
+	"github.com/tvarney/gotvm/vmopt"
+	"github.com/tvarney/gotvm/vmtest"
+)
+
+func TestCorpus(t *testing.T) {
+	t.Parallel()
+
+	double := vmopt.Native{Name: "double", Func: func(args []interface{}) ([]interface{}, error) {
+		results := make([]interface{}, 0, len(args)*2)
+		for _, arg := range args {
+			results = append(results, arg, arg)
+		}
+		return results, nil
+	}}
+	configs := map[string][]vmopt.Option{
+		"default": nil,
+		"strict":  {vmopt.WithStrict(true)},
+		"natives": {vmopt.WithNatives(double)},
+		"limits":  {vmopt.WithMaxStack(4), vmopt.WithMaxFrames(1), vmopt.WithGasLimit(50)},
+	}
+
+	for name, code := range vmtest.Corpus(t) {
+		code := code
+		for config, opts := range configs {
+			opts := opts
+			t.Run(name+"/"+config, func(t *testing.T) {
+				t.Parallel()
+				vmtest.Compare(t, code, opts...)
+			})
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	for name, code := range vmtest.Corpus(t) {
+		code := code
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fast, ref := &vmtest.Recorder{}, &vmtest.Recorder{}
+			vmtest.RunFast(code, vmopt.WithTracer(fast))
+			vmtest.RunReference(code, vmopt.WithTracer(ref))
+			assert.NotEmpty(t, ref.Events)
+			assert.Equal(t, ref.Events, fast.Events)
+		})
+	}
+}
+
+func TestRecorderDepth(t *testing.T) {
+	t.Parallel()
+
+	recorder := &vmtest.Recorder{Depth: 2}
+	vmtest.RunFast(vmtest.Assemble(t, "PushI32 1\nPushI32 2\nPushI32 3\nHalt"), vmopt.WithTracer(recorder))
+	assert.Equal(t, []string{
+		"0: PushI32 0 []",
+		"2: PushI32 1 [1]",
+		"4: PushI32 2 [1 2]",
+		"6: Halt 3 [2 3]",
+		"halt [1 2 3]",
+	}, recorder.Events)
+}
+
+func TestCorpusCoverage(t *testing.T) {
+	t.Parallel()
+
+	used := map[op.Op]bool{}
+	for _, code := range vmtest.Corpus(t) {
+		for idx := 0; idx < len(code); idx += code[idx].Size() {
+			used[code[idx]] = true
+		}
+	}
+	for opcode := op.Op(0); opcode.Valid(); opcode++ {
+		assert.True(t, used[opcode], "%s is not used by any program in testdata", opcode)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	s := func(values ...interface{}) []interface{} {
+		return values
+	}
+	r := func(stack []interface{}, err error) vmtest.Result {
+		return vmtest.Result{Stack: stack, Err: err}
+	}
+
+	tests := []struct {
+		Name     string
+		Fast     vmtest.Result
+		Ref      vmtest.Result
+		Expected bool
+	}{
+		{"equal", r(s(int64(1)), nil), r(s(int64(1)), nil), true},
+		{"different-value", r(s(int64(1)), nil), r(s(int64(2)), nil), false},
+		{"different-type", r(s(int64(1)), nil), r(s(uint64(1)), nil), false},
+		{"different-length", r(s(int64(1)), nil), r(s(), nil), false},
+		{"nan", r(s(math.NaN()), nil), r(s(math.NaN()), nil), true},
+		{"only-fast-error", r(nil, vmerr.ErrTooFewValues), r(nil, nil), false},
+		{"only-reference-error", r(nil, nil), r(nil, vmerr.ErrTooFewValues), false},
+		{
+			"same-error",
+			r(s(int64(1)), vmerr.TooFewValuesError{OpCode: "Pop"}),
+			r(s(), vmerr.TooFewValuesError{OpCode: "Pop"}),
+			true,
+		},
+		{
+			"different-error",
+			r(nil, vmerr.TooFewValuesError{OpCode: "Pop"}),
+			r(nil, vmerr.IndexOutOfBoundsError{OpCode: "Pop"}),
+			false,
+		},
+		{
+			"different-gas",
+			vmtest.Result{Stack: s(int64(1)), GasUsed: 2},
+			vmtest.Result{Stack: s(int64(1)), GasUsed: 3},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+			diff := vmtest.Diff(test.Fast, test.Ref)
+			assert.Equal(t, test.Expected, diff == "", diff)
+		})
+	}
+}
+
+// fuzzSteps is the instruction budget for programs run by FuzzExecute
+const fuzzSteps = 10000
+
+func FuzzExecute(f *testing.F) {
+	for _, code := range vmtest.Corpus(f) {
+		f.Add(vmtest.BytesFromCode(code))
+	}
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		code := vmtest.CodeFromBytes(data)
+
+		ref, finished := vmtest.RunReferenceSteps(code, fuzzSteps)
+		if !finished {
+			t.Skip("program did not finish within the instruction budget")
+		}
+		// The fast VM gets more gas than the reference VM used, so that it
+		// runs out rather than looping forever if the two diverge
+		fast := vmtest.RunFast(code, vmopt.WithGasLimit(ref.GasUsed+1))
+		if diff := vmtest.Diff(fast, ref); diff != "" {
+			t.Errorf("VMs differ for bytecode %v\n%s", code, diff)
+		}
+	})
+}